@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"casbin-demo/enforcer"
+	"casbin-demo/policy"
+	"casbin-demo/routes"
+)
+
+// accessMatrix prints the role × endpoint matrix, or diffs it against a baseline
+func accessMatrix(args []string, reg *routes.Registry) error {
+	flags := flag.NewFlagSet("access-matrix", flag.ContinueOnError)
+	format := flags.String("format", "csv", "output format: csv, json or markdown")
+	out := flags.String("out", "", "output file (default: stdout)")
+	baseline := flags.String("baseline", "", "CSV matrix to compare against instead of printing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := enforcer.InitializeEnforcer(); err != nil {
+		return err
+	}
+
+	matrix, err := policy.AccessMatrix(reg)
+	if err != nil {
+		return err
+	}
+
+	if *baseline != "" {
+		file, err := os.Open(*baseline)
+		if err != nil {
+			return fmt.Errorf("failed to open baseline: %v", err)
+		}
+		defer file.Close()
+
+		expected, err := policy.ReadMatrixCSV(file)
+		if err != nil {
+			return fmt.Errorf("invalid baseline: %v", err)
+		}
+
+		changes := policy.Diff(expected, matrix)
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		if len(changes) > 0 {
+			return fmt.Errorf("access matrix differs from %s in %d places", *baseline, len(changes))
+		}
+		fmt.Println("Access matrix matches", *baseline)
+		return nil
+	}
+
+	output := os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	return matrix.Write(output, *format)
+}
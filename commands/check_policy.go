@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"fmt"
+
+	"casbin-demo/enforcer"
+	"casbin-demo/policy"
+	"casbin-demo/routes"
+)
+
+// checkPolicy runs the policy coverage check and fails on any finding
+func checkPolicy(reg *routes.Registry) error {
+	if err := enforcer.InitializeEnforcer(); err != nil {
+		return err
+	}
+
+	report, err := policy.Coverage(reg)
+	if err != nil {
+		return err
+	}
+
+	for _, finding := range report.Findings() {
+		fmt.Println(finding)
+	}
+	if !report.OK() {
+		return fmt.Errorf("policy coverage check failed with %d findings", len(report.Findings()))
+	}
+
+	fmt.Println("Policy coverage check passed")
+	return nil
+}
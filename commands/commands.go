@@ -0,0 +1,25 @@
+package commands
+
+import (
+	"fmt"
+
+	"casbin-demo/routes"
+)
+
+// Run dispatches a command line subcommand
+func Run(args []string, reg *routes.Registry) error {
+	switch args[0] {
+	case "migrate-policy":
+		return migratePolicy(args[1:], reg)
+	case "check-policy":
+		return checkPolicy(reg)
+	case "access-matrix":
+		return accessMatrix(args[1:], reg)
+	case "policy-test":
+		return policyTest(args[1:], reg)
+	case "reconcile-stock":
+		return reconcileStock()
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
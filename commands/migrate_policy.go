@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"casbin-demo/routes"
+)
+
+// migratePolicy rewrites path-based p rules in a policy file as rules on named
+// route permissions. g rules, comments and non-path rules are kept as they are.
+func migratePolicy(args []string, reg *routes.Registry) error {
+	flags := flag.NewFlagSet("migrate-policy", flag.ContinueOnError)
+	in := flags.String("in", "./config/policy.csv", "policy file to convert")
+	out := flags.String("out", "", "output file (default: stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		return fmt.Errorf("failed to read policy: %v", err)
+	}
+
+	var output strings.Builder
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "p,") {
+			output.WriteString(line + "\n")
+			continue
+		}
+
+		reader := csv.NewReader(strings.NewReader(trimmed))
+		reader.TrimLeadingSpace = true
+		tokens, err := reader.Read()
+		if err != nil {
+			return fmt.Errorf("invalid policy line %q: %v", line, err)
+		}
+
+		migrated, ok := routes.MigrateRule(tokens[1:], reg.Routes())
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Dropping rule that matches no route:", trimmed)
+			continue
+		}
+		for _, rule := range migrated {
+			line := "p, " + strings.Join(rule, ", ")
+			if seen[line] {
+				continue
+			}
+			seen[line] = true
+			output.WriteString(line + "\n")
+		}
+	}
+
+	if *out == "" {
+		fmt.Print(output.String())
+		return nil
+	}
+	return os.WriteFile(*out, []byte(output.String()), 0644)
+}
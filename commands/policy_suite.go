@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"fmt"
+
+	"casbin-demo/policy"
+	"casbin-demo/routes"
+)
+
+// policyTest runs each policy test suite file and fails if any case fails
+func policyTest(args []string, reg *routes.Registry) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: policy-test <suite.json>...")
+	}
+
+	failed := 0
+	for _, path := range args {
+		result, err := policy.RunSuiteFile(path, reg)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range result.Cases {
+			if c.Passed {
+				fmt.Printf("PASS %s: %s\n", path, c.Case.Name)
+				continue
+			}
+			failed++
+			fmt.Printf("FAIL %s: %s\n     %s\n", path, c.Case.Name, c.Explanation)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d policy test cases failed", failed)
+	}
+	fmt.Println("Policy tests passed")
+	return nil
+}
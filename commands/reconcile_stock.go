@@ -0,0 +1,22 @@
+package commands
+
+import (
+	"fmt"
+
+	"casbin-demo/database"
+	"casbin-demo/jobs"
+)
+
+// reconcileStock checks product quantities against the stock ledger once
+func reconcileStock() error {
+	if err := database.InitializeDatabase(); err != nil {
+		return err
+	}
+
+	if err := jobs.ReconcileStock(); err != nil {
+		return err
+	}
+
+	fmt.Println("Stock matches the ledger")
+	return nil
+}
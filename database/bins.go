@@ -0,0 +1,453 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrBinNotFound  = errors.New("bin not found in this warehouse")
+	ErrDuplicateBin = errors.New("the warehouse already has a bin at this location")
+)
+
+const binColumns = "id, warehouse_id, zone, aisle, shelf, bin"
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func scanBin(row rowScanner) (models.Bin, error) {
+	var bin models.Bin
+	if err := row.Scan(&bin.ID, &bin.WarehouseID, &bin.Zone, &bin.Aisle, &bin.Shelf, &bin.Bin); err != nil {
+		return bin, err
+	}
+	bin.Code = strings.Join([]string{bin.Zone, bin.Aisle, bin.Shelf, bin.Bin}, "-")
+	return bin, nil
+}
+
+// compareLabels orders location labels numerically when both are numbers,
+// so aisle 9 comes before aisle 10, and as text otherwise
+func compareLabels(a, b string) int {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return x - y
+	}
+	return strings.Compare(a, b)
+}
+
+// sortWalkingPath orders bins the way a picker walks past them: zone by
+// zone and aisle by aisle, up every other aisle and back down the ones in
+// between, so no aisle is walked twice. Each bin's Sequence is its position.
+func sortWalkingPath(bins []models.Bin) {
+	sort.SliceStable(bins, func(i, j int) bool {
+		a, b := bins[i], bins[j]
+		for _, c := range []int{
+			compareLabels(a.Zone, b.Zone),
+			compareLabels(a.Aisle, b.Aisle),
+			compareLabels(a.Shelf, b.Shelf),
+			compareLabels(a.Bin, b.Bin),
+		} {
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	aisle := 0
+	for start := 0; start < len(bins); {
+		end := start
+		for end < len(bins) && bins[end].Zone == bins[start].Zone && bins[end].Aisle == bins[start].Aisle {
+			end++
+		}
+		if start == 0 || bins[start].Zone != bins[start-1].Zone {
+			aisle = 0
+		} else {
+			aisle++
+		}
+		if aisle%2 == 1 {
+			for i, j := start, end-1; i < j; i, j = i+1, j-1 {
+				bins[i], bins[j] = bins[j], bins[i]
+			}
+		}
+		start = end
+	}
+
+	for i := range bins {
+		bins[i].Sequence = i + 1
+	}
+}
+
+// walkingPath returns every bin of a warehouse in walking path order
+func walkingPath(q queryer, warehouseID int) ([]models.Bin, error) {
+	rows, err := q.Query("SELECT "+binColumns+" FROM bins WHERE warehouse_id = $1", warehouseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bins: %v", err)
+	}
+	defer rows.Close()
+
+	bins := []models.Bin{}
+	for rows.Next() {
+		bin, err := scanBin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan bin row: %v", err)
+		}
+		bins = append(bins, bin)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bin rows: %v", err)
+	}
+	sortWalkingPath(bins)
+	return bins, nil
+}
+
+// ListBins returns a warehouse's bins in walking path order
+func ListBins(warehouseID int) ([]models.Bin, error) {
+	return walkingPath(db, warehouseID)
+}
+
+// GetBin returns a bin by ID; its Sequence is not set
+func GetBin(id int) (models.Bin, error) {
+	bin, err := scanBin(db.QueryRow("SELECT "+binColumns+" FROM bins WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return bin, ErrBinNotFound
+	}
+	if err != nil {
+		return bin, fmt.Errorf("failed to read bin: %v", err)
+	}
+	return bin, nil
+}
+
+func CreateBin(warehouseID int, req models.BinRequest) (models.Bin, error) {
+	bin, err := scanBin(db.QueryRow(`
+        INSERT INTO bins (warehouse_id, zone, aisle, shelf, bin) VALUES ($1, $2, $3, $4, $5)
+        RETURNING `+binColumns, warehouseID, req.Zone, req.Aisle, req.Shelf, req.Bin))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Constraint {
+			case "bins_location_key":
+				return bin, ErrDuplicateBin
+			case "bins_warehouse_id_fkey":
+				return bin, ErrWarehouseNotFound
+			}
+		}
+		return bin, fmt.Errorf("failed to create bin: %v", err)
+	}
+	return bin, nil
+}
+
+// productBins returns a product's stock in the bins of a warehouse, in
+// walking path order
+func productBins(q queryer, productID, warehouseID int) ([]models.BinBalance, error) {
+	path, err := walkingPath(q, warehouseID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+        SELECT s.bin_id, s.quantity
+        FROM bin_stock s
+        JOIN bins b ON b.id = s.bin_id
+        WHERE s.product_id = $1 AND b.warehouse_id = $2 AND s.quantity > 0`, productID, warehouseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bin stock: %v", err)
+	}
+	defer rows.Close()
+
+	quantities := map[int]int{}
+	for rows.Next() {
+		var binID, quantity int
+		if err := rows.Scan(&binID, &quantity); err != nil {
+			return nil, fmt.Errorf("failed to scan bin stock row: %v", err)
+		}
+		quantities[binID] = quantity
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bin stock rows: %v", err)
+	}
+
+	balances := []models.BinBalance{}
+	for _, bin := range path {
+		if quantity, ok := quantities[bin.ID]; ok {
+			balances = append(balances, models.BinBalance{BinID: bin.ID, BinCode: bin.Code, Quantity: quantity})
+		}
+	}
+	return balances, nil
+}
+
+// unassignedStock returns how much of a product's balance in a warehouse is in no bin
+func unassignedStock(tx *sql.Tx, productID, warehouseID int) (int, error) {
+	var unassigned int
+	err := tx.QueryRow(`
+        SELECT COALESCE((SELECT quantity FROM stock_balances WHERE product_id = $1 AND warehouse_id = $2), 0)
+             - COALESCE((SELECT SUM(s.quantity) FROM bin_stock s JOIN bins b ON b.id = s.bin_id
+                         WHERE s.product_id = $1 AND b.warehouse_id = $2), 0)`,
+		productID, warehouseID).Scan(&unassigned)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read unassigned stock: %v", err)
+	}
+	return unassigned, nil
+}
+
+// checkBin returns ErrBinNotFound unless the bin is in the warehouse
+func checkBin(tx *sql.Tx, binID, warehouseID int) error {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM bins WHERE id = $1 AND warehouse_id = $2)", binID, warehouseID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	if !exists {
+		return ErrBinNotFound
+	}
+	return nil
+}
+
+// moveBinStock moves quantity of a product between two bins of a warehouse,
+// where bin 0 is the warehouse's unassigned stock, and records a BIN_MOVE
+// ledger entry. The caller must hold the product row lock.
+func moveBinStock(tx *sql.Tx, op models.Operation, warehouseID, fromBinID, toBinID, quantity int, reason string) (models.BinMove, error) {
+	move := models.BinMove{ProductID: op.ProductID, WarehouseID: warehouseID, Quantity: quantity, Reason: reason}
+	if fromBinID != 0 {
+		move.FromBinID = &fromBinID
+	}
+	if toBinID != 0 {
+		move.ToBinID = &toBinID
+	}
+
+	if fromBinID == 0 {
+		unassigned, err := unassignedStock(tx, op.ProductID, warehouseID)
+		if err != nil {
+			return move, err
+		}
+		if unassigned < quantity {
+			return move, ErrInsufficientStock
+		}
+	} else {
+		if err := checkBin(tx, fromBinID, warehouseID); err != nil {
+			return move, err
+		}
+		result, err := tx.Exec(`
+            UPDATE bin_stock SET quantity = quantity - $3
+            WHERE product_id = $1 AND bin_id = $2 AND quantity >= $3`, op.ProductID, fromBinID, quantity)
+		if err != nil {
+			return move, fmt.Errorf("failed to update bin stock: %v", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return move, ErrInsufficientStock
+		}
+	}
+
+	if toBinID != 0 {
+		if err := checkBin(tx, toBinID, warehouseID); err != nil {
+			return move, err
+		}
+		_, err := tx.Exec(`
+            INSERT INTO bin_stock (product_id, bin_id, quantity) VALUES ($1, $2, $3)
+            ON CONFLICT (product_id, bin_id)
+            DO UPDATE SET quantity = bin_stock.quantity + EXCLUDED.quantity`, op.ProductID, toBinID, quantity)
+		if err != nil {
+			return move, fmt.Errorf("failed to update bin stock: %v", err)
+		}
+	}
+
+	err := tx.QueryRow(`
+        INSERT INTO bin_moves (product_id, warehouse_id, from_bin_id, to_bin_id, quantity, reason, created_by)
+        VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0))
+        RETURNING id, created_at`,
+		op.ProductID, warehouseID, move.FromBinID, move.ToBinID, quantity, reason, op.UserID).
+		Scan(&move.ID, &move.CreatedAt)
+	if err != nil {
+		return move, fmt.Errorf("failed to record bin move: %v", err)
+	}
+
+	// A bin move changes neither the product's total nor the warehouse balance
+	var total, warehouseBalance int
+	var unitPrice float64
+	err = tx.QueryRow(`
+        SELECT p.quantity, p.unit_price, COALESCE(s.quantity, 0)
+        FROM products p
+        LEFT JOIN stock_balances s ON s.product_id = p.id AND s.warehouse_id = $2
+        WHERE p.id = $1`, op.ProductID, warehouseID).Scan(&total, &unitPrice, &warehouseBalance)
+	if err != nil {
+		return move, fmt.Errorf("database error: %v", err)
+	}
+
+	entry := ledgerEntry{
+		ProductID:        op.ProductID,
+		Type:             models.OperationBinMove,
+		Reason:           reason,
+		UserID:           op.UserID,
+		ActorID:          op.ActorID,
+		Balance:          total,
+		UnitCost:         unitPrice,
+		UnitPrice:        unitPrice,
+		WarehouseID:      warehouseID,
+		WarehouseBalance: warehouseBalance,
+		BinMoveID:        move.ID,
+	}
+	if err := recordOperation(tx, entry); err != nil {
+		return move, err
+	}
+	return move, nil
+}
+
+// pickStock takes quantity of a product off the shelves of a warehouse ahead
+// of a removal: all of it from binID when that is set, otherwise whatever the
+// unassigned stock does not cover, from bins along the walking path. Any
+// shortfall is left for the balance change to reject.
+func pickStock(tx *sql.Tx, op models.Operation, warehouseID, binID, quantity int, reason string) error {
+	if binID != 0 {
+		_, err := moveBinStock(tx, op, warehouseID, binID, 0, quantity, reason)
+		return err
+	}
+
+	unassigned, err := unassignedStock(tx, op.ProductID, warehouseID)
+	if err != nil {
+		return err
+	}
+	shortfall := quantity - unassigned
+	if shortfall <= 0 {
+		return nil
+	}
+
+	bins, err := productBins(tx, op.ProductID, warehouseID)
+	if err != nil {
+		return err
+	}
+	for _, bin := range bins {
+		if shortfall == 0 {
+			break
+		}
+		take := min(shortfall, bin.Quantity)
+		if _, err := moveBinStock(tx, op, warehouseID, bin.BinID, 0, take, reason); err != nil {
+			return err
+		}
+		shortfall -= take
+	}
+	return nil
+}
+
+// MoveBinStock moves stock of a product between bins of a warehouse
+func MoveBinStock(op models.Operation, warehouseID int, req models.BinMoveRequest) (models.BinMove, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return models.BinMove{}, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRow("SELECT id FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", op.ProductID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return models.BinMove{}, ErrProductNotFound
+	}
+	if err != nil {
+		return models.BinMove{}, fmt.Errorf("database error: %v", err)
+	}
+
+	move, err := moveBinStock(tx, op, warehouseID, req.FromBinID, req.ToBinID, req.Quantity, reasonOrDefault(req.Reason, "Bin move"))
+	if err != nil {
+		return move, err
+	}
+	return move, tx.Commit()
+}
+
+// SuggestBins suggests up to limit bins to put a product away in: first the
+// bins that already hold it, then empty bins, each in walking path order
+func SuggestBins(productID, warehouseID, limit int) ([]models.Bin, error) {
+	path, err := walkingPath(db, warehouseID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+        SELECT s.bin_id, BOOL_OR(s.product_id = $1)
+        FROM bin_stock s
+        JOIN bins b ON b.id = s.bin_id
+        WHERE b.warehouse_id = $2 AND s.quantity > 0
+        GROUP BY s.bin_id`, productID, warehouseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bin stock: %v", err)
+	}
+	defer rows.Close()
+
+	holdsProduct := map[int]bool{}
+	for rows.Next() {
+		var binID int
+		var holds bool
+		if err := rows.Scan(&binID, &holds); err != nil {
+			return nil, fmt.Errorf("failed to scan bin stock row: %v", err)
+		}
+		holdsProduct[binID] = holds
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bin stock rows: %v", err)
+	}
+
+	suggestions := []models.Bin{}
+	for _, bin := range path {
+		if holdsProduct[bin.ID] && len(suggestions) < limit {
+			suggestions = append(suggestions, bin)
+		}
+	}
+	for _, bin := range path {
+		if _, used := holdsProduct[bin.ID]; !used && len(suggestions) < limit {
+			suggestions = append(suggestions, bin)
+		}
+	}
+	return suggestions, nil
+}
+
+// GetPickableStock returns the shelved stock of the products in a warehouse,
+// bin by bin in walking path order
+func GetPickableStock(warehouseID int, productIDs []int) ([]models.BinStock, error) {
+	path, err := walkingPath(db, warehouseID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+        SELECT s.bin_id, p.id, p.sku, p.name, s.quantity
+        FROM bin_stock s
+        JOIN bins b ON b.id = s.bin_id
+        JOIN products p ON p.id = s.product_id
+        WHERE b.warehouse_id = $1 AND s.product_id = ANY($2) AND s.quantity > 0
+          AND p.deleted_at IS NULL`, warehouseID, pq.Array(productIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bin stock: %v", err)
+	}
+	defer rows.Close()
+
+	byBin := map[int][]models.BinStock{}
+	for rows.Next() {
+		var binID int
+		var stock models.BinStock
+		if err := rows.Scan(&binID, &stock.ProductID, &stock.SKU, &stock.Name, &stock.Quantity); err != nil {
+			return nil, fmt.Errorf("failed to scan bin stock row: %v", err)
+		}
+		byBin[binID] = append(byBin[binID], stock)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bin stock rows: %v", err)
+	}
+
+	stock := []models.BinStock{}
+	for _, bin := range path {
+		lines := byBin[bin.ID]
+		sort.Slice(lines, func(i, j int) bool { return lines[i].ProductID < lines[j].ProductID })
+		for _, line := range lines {
+			line.Bin = bin
+			stock = append(stock, line)
+		}
+	}
+	return stock, nil
+}
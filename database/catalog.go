@@ -0,0 +1,180 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateSKU     = errors.New("another product already has this SKU")
+	ErrDuplicateBarcode = errors.New("another product already has this barcode")
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrUnknownUnit      = errors.New("the product has no such purchase unit")
+)
+
+// productColumns is the column list scanned by scanProduct; queries alias products as p
+const productColumns = `p.id, p.name, p.unit_price, p.quantity, p.version, p.sku, p.category_id, p.base_unit, p.attributes,
+    COALESCE((SELECT array_agg(b.barcode ORDER BY b.barcode) FROM product_barcodes b WHERE b.product_id = p.id), '{}'),
+    COALESCE((SELECT jsonb_object_agg(u.unit, u.factor) FROM product_units u WHERE u.product_id = p.id), '{}'),
+    p.deleted_at`
+
+// scanProduct scans productColumns followed by any extra columns
+func scanProduct(row rowScanner, extra ...interface{}) (models.Product, error) {
+	var product models.Product
+	var attributes, units []byte
+	dest := []interface{}{
+		&product.ID, &product.Name, &product.UnitPrice, &product.Quantity, &product.Version,
+		&product.SKU, &product.CategoryID, &product.BaseUnit, &attributes,
+		pq.Array(&product.Barcodes), &units, &product.DeletedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return product, err
+	}
+
+	if err := json.Unmarshal(attributes, &product.Attributes); err != nil {
+		return product, fmt.Errorf("invalid attributes of product %d: %v", product.ID, err)
+	}
+	if err := json.Unmarshal(units, &product.PurchaseUnits); err != nil {
+		return product, fmt.Errorf("invalid purchase units of product %d: %v", product.ID, err)
+	}
+	return product, nil
+}
+
+// catalogError translates a constraint violation on product master data into
+// one of the errors above, or returns nil for any other error
+func catalogError(err error) error {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return nil
+	}
+	switch pqErr.Constraint {
+	case "products_sku_key":
+		return ErrDuplicateSKU
+	case "product_barcodes_pkey":
+		return ErrDuplicateBarcode
+	case "products_category_id_fkey", "categories_parent_id_fkey":
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+func attributesOrEmpty(attributes map[string]interface{}) map[string]interface{} {
+	if attributes == nil {
+		return map[string]interface{}{}
+	}
+	return attributes
+}
+
+func attributesJSON(attributes map[string]interface{}) string {
+	data, _ := json.Marshal(attributesOrEmpty(attributes))
+	return string(data)
+}
+
+func unitsOrEmpty(units map[string]float64) map[string]float64 {
+	if units == nil {
+		return map[string]float64{}
+	}
+	return units
+}
+
+func sameBarcodes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// attributeFilters returns the JSONB documents an attribute filter value
+// matches. The value always matches as a string, and also as a number or
+// boolean when it reads as one, so color=red and pack_size=6 both work.
+func attributeFilters(key, value string) []string {
+	candidates := []interface{}{value}
+
+	var typed interface{}
+	if err := json.Unmarshal([]byte(value), &typed); err == nil {
+		switch typed.(type) {
+		case float64, bool:
+			candidates = append(candidates, typed)
+		}
+	}
+
+	documents := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		data, _ := json.Marshal(map[string]interface{}{key: candidate})
+		documents = append(documents, string(data))
+	}
+	return documents
+}
+
+// setBarcodes replaces a product's barcodes
+func setBarcodes(tx *sql.Tx, productID int, barcodes []string) error {
+	if _, err := tx.Exec("DELETE FROM product_barcodes WHERE product_id = $1", productID); err != nil {
+		return fmt.Errorf("failed to clear barcodes: %v", err)
+	}
+	for _, barcode := range barcodes {
+		_, err := tx.Exec("INSERT INTO product_barcodes (barcode, product_id) VALUES ($1, $2)", barcode, productID)
+		if err != nil {
+			if catalogErr := catalogError(err); catalogErr != nil {
+				return catalogErr
+			}
+			return fmt.Errorf("failed to add barcode: %v", err)
+		}
+	}
+	return nil
+}
+
+// setPurchaseUnits replaces a product's purchase units and their conversion factors
+func setPurchaseUnits(tx *sql.Tx, productID int, units map[string]float64) error {
+	if _, err := tx.Exec("DELETE FROM product_units WHERE product_id = $1", productID); err != nil {
+		return fmt.Errorf("failed to clear purchase units: %v", err)
+	}
+	for unit, factor := range units {
+		_, err := tx.Exec("INSERT INTO product_units (product_id, unit, factor) VALUES ($1, $2, $3)", productID, unit, factor)
+		if err != nil {
+			return fmt.Errorf("failed to add purchase unit: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetProductByBarcode returns the product a barcode belongs to
+func GetProductByBarcode(barcode string) (models.Product, error) {
+	return scanProduct(db.QueryRow(`SELECT `+productColumns+` FROM products p
+        WHERE p.id = (SELECT product_id FROM product_barcodes WHERE barcode = $1) AND p.deleted_at IS NULL`, barcode))
+}
+
+// GetProductUnitFactor returns how many base units one unit of a product
+// holds: 1 for its base unit, or the factor of one of its purchase units
+func GetProductUnitFactor(productID int, unit string) (float64, error) {
+	var factor sql.NullFloat64
+	err := db.QueryRow(`
+        SELECT CASE WHEN p.base_unit = $2 THEN 1 ELSE u.factor END
+        FROM products p
+        LEFT JOIN product_units u ON u.product_id = p.id AND u.unit = $2
+        WHERE p.id = $1 AND p.deleted_at IS NULL`, productID, unit).Scan(&factor)
+	if err == sql.ErrNoRows {
+		return 0, ErrProductNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get purchase unit: %v", err)
+	}
+	if !factor.Valid {
+		return 0, ErrUnknownUnit
+	}
+	return factor.Float64, nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateCategory is returned when a parent already has a category with the name
+var ErrDuplicateCategory = errors.New("a category with this name already exists here")
+
+// categoryTree lists every category with the path from its root
+const categoryTree = `
+        WITH RECURSIVE tree AS (
+            SELECT id, name, parent_id, name::text AS path
+            FROM categories
+            WHERE parent_id IS NULL
+            UNION ALL
+            SELECT c.id, c.name, c.parent_id, tree.path || ' / ' || c.name
+            FROM categories c
+            JOIN tree ON c.parent_id = tree.id
+        )`
+
+// CreateCategory adds a category under parentID, or at the root when it is nil
+func CreateCategory(req models.CategoryRequest) (models.Category, error) {
+	var id int
+	err := db.QueryRow("INSERT INTO categories (name, parent_id) VALUES ($1, $2) RETURNING id", req.Name, req.ParentID).Scan(&id)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "categories_name_key" {
+			return models.Category{}, ErrDuplicateCategory
+		}
+		if catalogErr := catalogError(err); catalogErr != nil {
+			return models.Category{}, catalogErr
+		}
+		return models.Category{}, fmt.Errorf("failed to create category: %v", err)
+	}
+
+	var category models.Category
+	err = db.QueryRow(categoryTree+" SELECT id, name, parent_id, path FROM tree WHERE id = $1", id).
+		Scan(&category.ID, &category.Name, &category.ParentID, &category.Path)
+	if err != nil {
+		return category, fmt.Errorf("failed to read category: %v", err)
+	}
+	return category, nil
+}
+
+// ListCategories returns the category tree, each parent before its children
+func ListCategories() ([]models.Category, error) {
+	rows, err := db.Query(categoryTree + " SELECT id, name, parent_id, path FROM tree ORDER BY path")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query categories: %v", err)
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		var category models.Category
+		if err := rows.Scan(&category.ID, &category.Name, &category.ParentID, &category.Path); err != nil {
+			return nil, fmt.Errorf("failed to scan category row: %v", err)
+		}
+		categories = append(categories, category)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating category rows: %v", err)
+	}
+	return categories, nil
+}
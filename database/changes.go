@@ -0,0 +1,185 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+var (
+	// ErrChangeNotFound is returned when a product has no adjustment with the given ID
+	ErrChangeNotFound = errors.New("change not found")
+	// ErrChangeConflict is returned when a field was changed again after the
+	// adjustment being reverted
+	ErrChangeConflict = errors.New("the product changed since; the change can no longer be reverted")
+)
+
+// productFieldValues returns the audited fields of a product as plain JSON
+// values, so they compare equal to values decoded from a stored diff
+func productFieldValues(p models.Product) map[string]interface{} {
+	barcodes := append([]string{}, p.Barcodes...)
+	sort.Strings(barcodes)
+
+	fields := map[string]interface{}{
+		"name":           p.Name,
+		"unit_price":     p.UnitPrice,
+		"quantity":       p.Quantity,
+		"sku":            p.SKU,
+		"barcodes":       barcodes,
+		"category_id":    p.CategoryID,
+		"base_unit":      p.BaseUnit,
+		"purchase_units": unitsOrEmpty(p.PurchaseUnits),
+		"attributes":     attributesOrEmpty(p.Attributes),
+	}
+
+	var values map[string]interface{}
+	data, _ := json.Marshal(fields)
+	json.Unmarshal(data, &values)
+	return values
+}
+
+// diffProducts returns the before and after value of every audited field that differs
+func diffProducts(before, after models.Product) map[string]models.FieldChange {
+	old, updated := productFieldValues(before), productFieldValues(after)
+
+	changes := map[string]models.FieldChange{}
+	for field, value := range updated {
+		if !reflect.DeepEqual(old[field], value) {
+			changes[field] = models.FieldChange{Before: old[field], After: value}
+		}
+	}
+	return changes
+}
+
+func changedFields(changes map[string]models.FieldChange) []string {
+	fields := make([]string, 0, len(changes))
+	for field := range changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func decodeChanges(data []byte) (map[string]models.FieldChange, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var changes map[string]models.FieldChange
+	if err := json.Unmarshal(data, &changes); err != nil {
+		return nil, fmt.Errorf("invalid change diff: %v", err)
+	}
+	return changes, nil
+}
+
+// GetProductChanges returns the adjustments of a product that carry a field diff, newest first.
+// A purged product's diffs are withheld; see PurgeDeletedProducts.
+func GetProductChanges(productID int) ([]models.ProductChange, error) {
+	rows, err := db.Query(`
+        SELECT o.id, o.product_id, o.reason, COALESCE(o.created_by, 0),
+               COALESCE(u.username, 'system'), a.username,
+               CASE WHEN p.purged_at IS NULL THEN o.changes END, o.revert_of, o.created_at
+        FROM operations o
+        JOIN products p ON o.product_id = p.id
+        LEFT JOIN users u ON o.created_by = u.id
+        LEFT JOIN users a ON o.actor_id = a.id
+        WHERE o.product_id = $1 AND o.changes IS NOT NULL
+        ORDER BY o.id DESC`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product changes: %v", err)
+	}
+	defer rows.Close()
+
+	changes := []models.ProductChange{}
+	for rows.Next() {
+		var change models.ProductChange
+		var diff []byte
+		err := rows.Scan(&change.OperationID, &change.ProductID, &change.Reason, &change.CreatedBy,
+			&change.CreatedByUsername, &change.ActorUsername, &diff, &change.RevertOf, &change.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan product change row: %v", err)
+		}
+		if change.Changes, err = decodeChanges(diff); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating product change rows: %v", err)
+	}
+	return changes, nil
+}
+
+// RevertProductChange applies the inverse of an adjustment as a new adjustment
+// and returns the product's new version. Every field the adjustment changed
+// must still hold the value it was changed to.
+func RevertProductChange(op models.Operation, operationID int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var diff []byte
+	var warehouseID int
+	err = tx.QueryRow("SELECT changes, COALESCE(warehouse_id, 0) FROM operations WHERE id = $1 AND product_id = $2 AND changes IS NOT NULL",
+		operationID, op.ProductID).Scan(&diff, &warehouseID)
+	if err == sql.ErrNoRows {
+		return 0, ErrChangeNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	changes, err := decodeChanges(diff)
+	if err != nil {
+		return 0, err
+	}
+
+	// Lock the product so the comparison below still holds when the update runs
+	var locked int
+	err = tx.QueryRow("SELECT id FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", op.ProductID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return 0, ErrProductNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	current, err := scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products p WHERE p.id = $1", op.ProductID))
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+
+	values := productFieldValues(current)
+	previous := map[string]interface{}{}
+	for field, change := range changes {
+		if !reflect.DeepEqual(values[field], change.After) {
+			return 0, ErrChangeConflict
+		}
+		previous[field] = change.Before
+	}
+
+	// The previous values decode straight into an update; a category that
+	// was previously unset is cleared with 0
+	var update models.ProductUpdate
+	data, _ := json.Marshal(previous)
+	if err := json.Unmarshal(data, &update); err != nil {
+		return 0, fmt.Errorf("invalid change diff: %v", err)
+	}
+	if change, ok := changes["category_id"]; ok && change.Before == nil {
+		none := 0
+		update.CategoryID = &none
+	}
+	// A quantity change is undone in the warehouse it was counted in
+	update.WarehouseID = warehouseID
+
+	op.Reason = reasonOrDefault(op.Reason, fmt.Sprintf("Revert of operation %d", operationID))
+	version, err := updateProduct(tx, op, update, operationID)
+	if err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
@@ -2,7 +2,9 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 
 	"github.com/joho/godotenv"
@@ -18,8 +20,8 @@ func getEnvOrDefault(key, defaultValue string) string {
 }
 
 func InitializeDatabase() error {
-	// Load .env file
-	if err := godotenv.Load(); err != nil {
+	// Load .env file; without one the settings come from the environment
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return fmt.Errorf("error loading .env file: %v", err)
 	}
 
@@ -40,6 +42,16 @@ func InitializeDatabase() error {
 		return err
 	}
 
+	if err := migrate(); err != nil {
+		return err
+	}
+
 	fmt.Println("Database initialized successfully")
 	return nil
 }
+
+// SetDB makes the package use an already opened connection pool, such as a
+// test double, without running migrations
+func SetDB(conn *sql.DB) {
+	db = conn
+}
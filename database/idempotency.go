@@ -0,0 +1,73 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"fmt"
+	"time"
+)
+
+// ReserveIdempotencyKey claims a user's key for a request. It returns true
+// when the key is new, and otherwise the record left by the earlier request.
+// A key created before expiredBefore counts as new, whether or not the purge
+// job has deleted it yet.
+func ReserveIdempotencyKey(userID int, key, requestHash string, expiredBefore time.Time) (models.IdempotencyRecord, bool, error) {
+	record := models.IdempotencyRecord{UserID: userID, Key: key, RequestHash: requestHash}
+
+	result, err := db.Exec(`
+        INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, idempotency_key) DO UPDATE
+        SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL,
+            etag = NULL, location = NULL, response_body = NULL, created_at = CURRENT_TIMESTAMP
+        WHERE idempotency_keys.created_at < $4`,
+		userID, key, requestHash, expiredBefore)
+	if err != nil {
+		return record, false, fmt.Errorf("failed to reserve idempotency key: %v", err)
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 1 {
+		return record, true, nil
+	}
+
+	err = db.QueryRow(`
+        SELECT request_hash, COALESCE(status_code, 0), COALESCE(content_type, ''), COALESCE(etag, ''),
+            COALESCE(location, ''), COALESCE(response_body, ''::bytea), created_at
+        FROM idempotency_keys
+        WHERE user_id = $1 AND idempotency_key = $2`,
+		userID, key).Scan(&record.RequestHash, &record.StatusCode, &record.ContentType, &record.ETag,
+		&record.Location, &record.Body, &record.CreatedAt)
+	if err != nil {
+		return record, false, fmt.Errorf("failed to read idempotency key: %v", err)
+	}
+	return record, false, nil
+}
+
+// CompleteIdempotencyKey stores the response to replay for a reserved key
+func CompleteIdempotencyKey(record models.IdempotencyRecord) error {
+	_, err := db.Exec(`
+        UPDATE idempotency_keys
+        SET status_code = $1, content_type = $2, etag = $3, location = $4, response_body = $5
+        WHERE user_id = $6 AND idempotency_key = $7`,
+		record.StatusCode, record.ContentType, record.ETag, record.Location, record.Body, record.UserID, record.Key)
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %v", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a reserved key so the request can be retried
+func ReleaseIdempotencyKey(userID int, key string) error {
+	_, err := db.Exec("DELETE FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2", userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys deletes keys created before cutoff and returns how many were removed
+func PurgeIdempotencyKeys(cutoff time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM idempotency_keys WHERE created_at < $1", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %v", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ErrUsernameTaken is returned when an IdP account's username already belongs
+// to another user
+var ErrUsernameTaken = errors.New("username is taken by another account")
+
+const userIdentityKey = "users_oidc_identity_key"
+
+// GetUserByIdentity returns the user bound to an IdP account, including a
+// soft-deleted one so that deleting a user also locks out its IdP account
+func GetUserByIdentity(issuer, subject string) (models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE oidc_issuer=$1 AND oidc_subject=$2", issuer, subject))
+}
+
+// CreateIdentityUser creates a user bound to an IdP account. It never takes
+// over an existing account: a username in use, even by a deleted user, is
+// ErrUsernameTaken.
+func CreateIdentityUser(username, password, issuer, subject string) (models.User, error) {
+	user, err := scanUser(db.QueryRow(`
+        INSERT INTO users (username, password, oidc_issuer, oidc_subject)
+        SELECT $1, $2, $3, $4
+        WHERE NOT EXISTS (SELECT 1 FROM users WHERE username = $1)
+        RETURNING `+userColumns, username, password, issuer, subject))
+	if err == sql.ErrNoRows {
+		return user, ErrUsernameTaken
+	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		// A concurrent first login of the same IdP account got there first
+		if pqErr.Constraint == userIdentityKey {
+			return GetUserByIdentity(issuer, subject)
+		}
+		return user, ErrUsernameTaken
+	}
+	if err != nil {
+		return user, fmt.Errorf("failed to create user: %v", err)
+	}
+	return user, nil
+}
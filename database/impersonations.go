@@ -0,0 +1,53 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"fmt"
+)
+
+// CreateImpersonation records that an actor was issued a token to act as a target user
+func CreateImpersonation(imp models.Impersonation) (models.Impersonation, error) {
+	err := db.QueryRow(`
+        INSERT INTO impersonations (actor_id, target_id, reason, expires_at)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at`,
+		imp.ActorID, imp.TargetID, imp.Reason, imp.ExpiresAt).Scan(&imp.ID, &imp.CreatedAt)
+	if err != nil {
+		return imp, fmt.Errorf("failed to record impersonation: %v", err)
+	}
+	return imp, nil
+}
+
+// ListImpersonations returns impersonation records, newest first, optionally
+// limited to one actor or target username
+func ListImpersonations(actor, target string, offset, limit int) ([]models.Impersonation, error) {
+	rows, err := db.Query(`
+        SELECT i.id, i.actor_id, a.username, i.target_id, t.username, i.reason, i.expires_at, i.created_at
+        FROM impersonations i
+        JOIN users a ON i.actor_id = a.id
+        JOIN users t ON i.target_id = t.id
+        WHERE ($1 = '' OR a.username = $1) AND ($2 = '' OR t.username = $2)
+        ORDER BY i.created_at DESC, i.id DESC
+        OFFSET $3 LIMIT $4`,
+		actor, target, offset, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query impersonations: %v", err)
+	}
+	defer rows.Close()
+
+	impersonations := []models.Impersonation{}
+	for rows.Next() {
+		var imp models.Impersonation
+		err := rows.Scan(&imp.ID, &imp.ActorID, &imp.ActorUsername, &imp.TargetID, &imp.TargetUsername,
+			&imp.Reason, &imp.ExpiresAt, &imp.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan impersonation row: %v", err)
+		}
+		impersonations = append(impersonations, imp)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating impersonation rows: %v", err)
+	}
+	return impersonations, nil
+}
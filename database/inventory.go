@@ -0,0 +1,153 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// inventoryAsOf reconstructs every product's quantity and unit price at $1
+// from its latest snapshot taken by then plus the ledger entries after it
+const inventoryAsOf = `
+        WITH snap AS (
+            SELECT DISTINCT ON (product_id) product_id, quantity, unit_price, last_operation_id
+            FROM inventory_snapshots
+            WHERE taken_at <= $1
+            ORDER BY product_id, taken_at DESC
+        ),
+        moves AS (
+            SELECT o.product_id, SUM(o.quantity_delta) AS delta
+            FROM operations o
+            LEFT JOIN snap s ON s.product_id = o.product_id
+            WHERE o.created_at <= $1 AND o.id > COALESCE(s.last_operation_id, 0)
+            GROUP BY o.product_id
+        ),
+        price AS (
+            SELECT DISTINCT ON (o.product_id) o.product_id,
+                   COALESCE(o.unit_price, o.unit_cost) AS unit_price
+            FROM operations o
+            LEFT JOIN snap s ON s.product_id = o.product_id
+            WHERE o.created_at <= $1 AND o.id > COALESCE(s.last_operation_id, 0)
+              AND (o.unit_price IS NOT NULL OR o.type = 'OPENING_BALANCE')
+            ORDER BY o.product_id, o.created_at DESC, o.id DESC
+        )
+        SELECT p.id, p.name,
+               COALESCE(s.quantity, 0) + COALESCE(m.delta, 0) AS quantity,
+               COALESCE(pr.unit_price, s.unit_price, p.unit_price) AS unit_price,
+               p.deleted_at
+        FROM products p
+        LEFT JOIN snap s ON s.product_id = p.id
+        LEFT JOIN moves m ON m.product_id = p.id
+        LEFT JOIN price pr ON pr.product_id = p.id
+        WHERE s.product_id IS NOT NULL OR m.product_id IS NOT NULL`
+
+// GetInventoryAsOf returns the products that existed at asOf with their
+// quantity and unit price at that moment
+func GetInventoryAsOf(asOf time.Time) ([]models.InventoryItem, error) {
+	rows, err := db.Query(`
+        SELECT id, name, quantity, unit_price
+        FROM (`+inventoryAsOf+`) inventory
+        WHERE deleted_at IS NULL OR deleted_at > $1
+        ORDER BY id`, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inventory: %v", err)
+	}
+	defer rows.Close()
+
+	items := []models.InventoryItem{}
+	for rows.Next() {
+		var item models.InventoryItem
+		if err := rows.Scan(&item.ProductID, &item.Name, &item.Quantity, &item.UnitPrice); err != nil {
+			return nil, fmt.Errorf("failed to scan inventory row: %v", err)
+		}
+		item.Value = float64(item.Quantity) * item.UnitPrice
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating inventory rows: %v", err)
+	}
+	return items, nil
+}
+
+// SnapshotInventory stores every product's ledger quantity and price as of now,
+// including deleted products so they can be restored later
+func SnapshotInventory() (int, error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var takenAt time.Time
+	var lastOperationID int
+	err = tx.QueryRow("SELECT LOCALTIMESTAMP, COALESCE(MAX(id), 0) FROM operations").Scan(&takenAt, &lastOperationID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read ledger position: %v", err)
+	}
+
+	result, err := tx.Exec(`
+        INSERT INTO inventory_snapshots (taken_at, product_id, quantity, unit_price, last_operation_id)
+        SELECT $1, id, quantity, unit_price, $2
+        FROM (`+inventoryAsOf+`) inventory
+        ON CONFLICT (product_id, taken_at) DO NOTHING`, takenAt, lastOperationID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to snapshot inventory: %v", err)
+	}
+
+	count, _ := result.RowsAffected()
+	return int(count), tx.Commit()
+}
+
+// GetStockHistory returns a product's quantity before from and every ledger
+// entry between from and to, oldest first
+func GetStockHistory(productID int, from, to time.Time) (int, []models.StockLevel, error) {
+	var opening int
+	err := db.QueryRow(`
+        WITH snap AS (
+            SELECT quantity, last_operation_id
+            FROM inventory_snapshots
+            WHERE product_id = $1 AND taken_at < $2
+            ORDER BY taken_at DESC
+            LIMIT 1
+        )
+        SELECT COALESCE((SELECT quantity FROM snap), 0) + COALESCE(SUM(quantity_delta), 0)
+        FROM operations
+        WHERE product_id = $1 AND created_at < $2
+          AND id > COALESCE((SELECT last_operation_id FROM snap), 0)`,
+		productID, from).Scan(&opening)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to query opening stock: %v", err)
+	}
+
+	rows, err := db.Query(`
+        SELECT created_at, quantity_delta, type
+        FROM operations
+        WHERE product_id = $1 AND created_at >= $2 AND created_at <= $3
+        ORDER BY created_at, id`,
+		productID, from, to)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to query stock history: %v", err)
+	}
+	defer rows.Close()
+
+	levels := []models.StockLevel{}
+	balance := opening
+	for rows.Next() {
+		var level models.StockLevel
+		var delta int
+		if err := rows.Scan(&level.At, &delta, &level.Type); err != nil {
+			return 0, nil, fmt.Errorf("failed to scan stock history row: %v", err)
+		}
+		balance += delta
+		level.Quantity = balance
+		levels = append(levels, level)
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error iterating stock history rows: %v", err)
+	}
+	return opening, levels, nil
+}
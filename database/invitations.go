@@ -0,0 +1,85 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ErrInvitationUnavailable is returned when an invitation is unknown, expired or already redeemed
+var ErrInvitationUnavailable = fmt.Errorf("invitation is invalid, expired or already redeemed")
+
+// CreateInvitation stores a new invitation under the hash of its token
+func CreateInvitation(inv models.Invitation, tokenHash string) (models.Invitation, error) {
+	groupsJSON, err := json.Marshal(inv.Groups)
+	if err != nil {
+		return inv, err
+	}
+
+	err = db.QueryRow(`
+        INSERT INTO invitations (token_hash, email, group_names, expires_at, created_by)
+        VALUES ($1, NULLIF($2, ''), $3, $4, $5)
+        RETURNING id`,
+		tokenHash, inv.Email, string(groupsJSON), inv.ExpiresAt, inv.CreatedBy).Scan(&inv.ID)
+	if err != nil {
+		return inv, fmt.Errorf("failed to create invitation: %v", err)
+	}
+	return inv, nil
+}
+
+// RedeemInvitation creates the invited user and marks the invitation redeemed in one transaction.
+// assignGroups runs before the commit, so a failure to place the user in its groups
+// leaves neither the user nor a redeemed invitation behind.
+func RedeemInvitation(tokenHash, username, password string, assignGroups func(groups []string) error) (models.User, error) {
+	var user models.User
+
+	tx, err := db.Begin()
+	if err != nil {
+		return user, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var invitationID int
+	var groupsJSON string
+	var expiresAt time.Time
+	err = tx.QueryRow(`
+        SELECT id, group_names, expires_at FROM invitations
+        WHERE token_hash = $1 AND redeemed_at IS NULL
+        FOR UPDATE`, tokenHash).Scan(&invitationID, &groupsJSON, &expiresAt)
+	if err == sql.ErrNoRows {
+		return user, ErrInvitationUnavailable
+	}
+	if err != nil {
+		return user, fmt.Errorf("database error: %v", err)
+	}
+	if time.Now().After(expiresAt) {
+		return user, ErrInvitationUnavailable
+	}
+
+	var groups []string
+	if err := json.Unmarshal([]byte(groupsJSON), &groups); err != nil {
+		return user, fmt.Errorf("invalid invitation groups: %v", err)
+	}
+
+	err = tx.QueryRow(`
+        INSERT INTO users (username, password) VALUES ($1, $2)
+        RETURNING id, username`, username, password).Scan(&user.ID, &user.Username)
+	if err != nil {
+		return user, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	_, err = tx.Exec(`
+        UPDATE invitations SET redeemed_at = CURRENT_TIMESTAMP, redeemed_by = $1
+        WHERE id = $2`, user.ID, invitationID)
+	if err != nil {
+		return user, fmt.Errorf("failed to redeem invitation: %v", err)
+	}
+
+	if err := assignGroups(groups); err != nil {
+		return user, err
+	}
+
+	return user, tx.Commit()
+}
@@ -0,0 +1,296 @@
+package database
+
+import (
+	"fmt"
+)
+
+// migrations are applied in order, once each, and tracked in schema_migrations.
+// Never edit an applied migration; append a new one instead.
+var migrations = []string{
+	// 1: user profile fields, suspension and restorable group memberships
+	`ALTER TABLE users
+        ADD COLUMN IF NOT EXISTS email TEXT,
+        ADD COLUMN IF NOT EXISTS full_name TEXT,
+        ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active'
+            CHECK (status IN ('active', 'suspended')),
+        ADD COLUMN IF NOT EXISTS deleted_groups TEXT`,
+
+	// 2: invitation-based onboarding
+	`CREATE TABLE IF NOT EXISTS invitations (
+        id SERIAL PRIMARY KEY,
+        token_hash TEXT NOT NULL UNIQUE,
+        email TEXT,
+        group_names TEXT NOT NULL,
+        expires_at TIMESTAMP NOT NULL,
+        created_by INTEGER NOT NULL REFERENCES users(id),
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        redeemed_at TIMESTAMP,
+        redeemed_by INTEGER REFERENCES users(id)
+    )`,
+
+	// 3: impersonation audit trail
+	`ALTER TABLE operations
+        ADD COLUMN IF NOT EXISTS actor_id INTEGER REFERENCES users(id);
+    CREATE TABLE IF NOT EXISTS impersonations (
+        id SERIAL PRIMARY KEY,
+        actor_id INTEGER NOT NULL REFERENCES users(id),
+        target_id INTEGER NOT NULL REFERENCES users(id),
+        reason TEXT NOT NULL,
+        expires_at TIMESTAMP NOT NULL,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+
+	// 4: operations become an append-only stock ledger. Existing stock is
+	// carried in as one opening balance entry per product, made by no user.
+	`ALTER TABLE operations
+        ADD COLUMN IF NOT EXISTS quantity_delta INTEGER NOT NULL DEFAULT 0,
+        ADD COLUMN IF NOT EXISTS balance_after INTEGER,
+        ADD COLUMN IF NOT EXISTS unit_cost NUMERIC(12, 2),
+        ALTER COLUMN created_by DROP NOT NULL;
+    INSERT INTO operations (product_id, type, reason, quantity_delta, balance_after, unit_cost)
+        SELECT id, 'OPENING_BALANCE', 'Ledger opening balance', quantity, quantity, unit_price
+        FROM products;
+    CREATE OR REPLACE FUNCTION reject_operation_change() RETURNS trigger AS $$
+    BEGIN
+        RAISE EXCEPTION 'operations is an append-only ledger';
+    END;
+    $$ LANGUAGE plpgsql;
+    DROP TRIGGER IF EXISTS operations_append_only ON operations;
+    CREATE TRIGGER operations_append_only
+        BEFORE UPDATE OR DELETE ON operations
+        FOR EACH ROW EXECUTE FUNCTION reject_operation_change()`,
+
+	// 5: point-in-time inventory. Ledger entries record the unit price after
+	// the operation, and periodic snapshots bound how much ledger is replayed.
+	`ALTER TABLE operations
+        ADD COLUMN IF NOT EXISTS unit_price NUMERIC(12, 2);
+    CREATE INDEX IF NOT EXISTS operations_product_created_idx
+        ON operations (product_id, created_at, id);
+    CREATE TABLE IF NOT EXISTS inventory_snapshots (
+        id SERIAL PRIMARY KEY,
+        taken_at TIMESTAMP NOT NULL,
+        product_id INTEGER NOT NULL REFERENCES products(id),
+        quantity INTEGER NOT NULL,
+        unit_price NUMERIC(12, 2) NOT NULL,
+        last_operation_id INTEGER NOT NULL,
+        UNIQUE (product_id, taken_at)
+    )`,
+
+	// 6: stock can never go negative, and write requests can be retried
+	// safely with an Idempotency-Key
+	`ALTER TABLE products
+        ADD CONSTRAINT products_quantity_non_negative CHECK (quantity >= 0);
+    CREATE TABLE IF NOT EXISTS idempotency_keys (
+        user_id INTEGER NOT NULL REFERENCES users(id),
+        idempotency_key TEXT NOT NULL,
+        request_hash TEXT NOT NULL,
+        status_code INTEGER,
+        content_type TEXT,
+        response_body BYTEA,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (user_id, idempotency_key)
+    )`,
+
+	// 7: optimistic concurrency. Every change to a product bumps its
+	// version, which clients see as the product's ETag.
+	`ALTER TABLE products
+        ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+    CREATE OR REPLACE FUNCTION bump_product_version() RETURNS trigger AS $$
+    BEGIN
+        NEW.version := OLD.version + 1;
+        RETURN NEW;
+    END;
+    $$ LANGUAGE plpgsql;
+    DROP TRIGGER IF EXISTS products_version ON products;
+    CREATE TRIGGER products_version
+        BEFORE UPDATE ON products
+        FOR EACH ROW EXECUTE FUNCTION bump_product_version()`,
+
+	// 8: product listing. Names are searchable through a tsvector, and the
+	// sortable columns are indexed together with id for keyset pagination.
+	`ALTER TABLE products
+        ADD COLUMN IF NOT EXISTS search_vector tsvector
+            GENERATED ALWAYS AS (to_tsvector('simple', COALESCE(name, ''))) STORED;
+    CREATE INDEX IF NOT EXISTS products_search_idx ON products USING GIN (search_vector);
+    CREATE INDEX IF NOT EXISTS products_name_idx ON products (name, id);
+    CREATE INDEX IF NOT EXISTS products_unit_price_idx ON products (unit_price, id);
+    CREATE INDEX IF NOT EXISTS products_quantity_idx ON products (quantity, id);
+    CREATE INDEX IF NOT EXISTS products_updated_at_idx ON products (updated_at, id)`,
+
+	// 9: product master data. Existing products get a SKU derived from their
+	// ID, and adjustments record which fields they changed.
+	`CREATE TABLE IF NOT EXISTS categories (
+        id SERIAL PRIMARY KEY,
+        name TEXT NOT NULL,
+        parent_id INTEGER REFERENCES categories(id),
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS categories_name_key
+        ON categories (COALESCE(parent_id, 0), LOWER(name));
+    ALTER TABLE products
+        ADD COLUMN IF NOT EXISTS sku TEXT,
+        ADD COLUMN IF NOT EXISTS category_id INTEGER REFERENCES categories(id),
+        ADD COLUMN IF NOT EXISTS base_unit TEXT NOT NULL DEFAULT 'each',
+        ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+    UPDATE products SET sku = 'SKU-' || id WHERE sku IS NULL;
+    ALTER TABLE products ALTER COLUMN sku SET NOT NULL;
+    CREATE UNIQUE INDEX IF NOT EXISTS products_sku_key ON products (sku);
+    CREATE INDEX IF NOT EXISTS products_category_idx ON products (category_id);
+    CREATE INDEX IF NOT EXISTS products_attributes_idx ON products USING GIN (attributes jsonb_path_ops);
+    CREATE TABLE IF NOT EXISTS product_barcodes (
+        barcode TEXT PRIMARY KEY,
+        product_id INTEGER NOT NULL REFERENCES products(id),
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS product_barcodes_product_idx ON product_barcodes (product_id);
+    CREATE TABLE IF NOT EXISTS product_units (
+        product_id INTEGER NOT NULL REFERENCES products(id),
+        unit TEXT NOT NULL,
+        factor NUMERIC(12, 4) NOT NULL CHECK (factor > 0),
+        PRIMARY KEY (product_id, unit)
+    );
+    ALTER TABLE operations
+        ADD COLUMN IF NOT EXISTS changed_fields TEXT[]`,
+
+	// 10: adjustments keep a before/after diff of the fields they changed,
+	// and reverts point at the adjustment they undo
+	`ALTER TABLE operations
+        ADD COLUMN IF NOT EXISTS changes JSONB,
+        ADD COLUMN IF NOT EXISTS revert_of INTEGER REFERENCES operations(id);
+    CREATE INDEX IF NOT EXISTS operations_changes_idx
+        ON operations (product_id, id) WHERE changes IS NOT NULL`,
+
+	// 11: deleted products are purged after a retention period. Purged
+	// products keep their row, anonymized, so the ledger stays intact.
+	`ALTER TABLE products
+        ADD COLUMN IF NOT EXISTS purged_at TIMESTAMP;
+    CREATE INDEX IF NOT EXISTS products_deleted_at_idx
+        ON products (deleted_at) WHERE deleted_at IS NOT NULL AND purged_at IS NULL`,
+
+	// 12: stock is held per warehouse. products.quantity stays the total
+	// across warehouses, and existing stock moves into the default warehouse.
+	// Ledger entries from before warehouses have none and count towards it.
+	`CREATE TABLE IF NOT EXISTS warehouses (
+        id SERIAL PRIMARY KEY,
+        code TEXT NOT NULL,
+        name TEXT NOT NULL,
+        is_default BOOLEAN NOT NULL DEFAULT FALSE,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS warehouses_code_key ON warehouses (UPPER(code));
+    CREATE UNIQUE INDEX IF NOT EXISTS warehouses_default_key ON warehouses (is_default) WHERE is_default;
+    INSERT INTO warehouses (code, name, is_default) VALUES ('MAIN', 'Main warehouse', TRUE);
+    CREATE TABLE IF NOT EXISTS stock_balances (
+        product_id INTEGER NOT NULL REFERENCES products(id),
+        warehouse_id INTEGER NOT NULL REFERENCES warehouses(id),
+        quantity INTEGER NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+        PRIMARY KEY (product_id, warehouse_id)
+    );
+    CREATE INDEX IF NOT EXISTS stock_balances_warehouse_idx ON stock_balances (warehouse_id, product_id);
+    INSERT INTO stock_balances (product_id, warehouse_id, quantity)
+        SELECT p.id, w.id, p.quantity FROM products p, warehouses w WHERE w.is_default;
+    CREATE TABLE IF NOT EXISTS stock_transfers (
+        id SERIAL PRIMARY KEY,
+        product_id INTEGER NOT NULL REFERENCES products(id),
+        from_warehouse_id INTEGER NOT NULL REFERENCES warehouses(id),
+        to_warehouse_id INTEGER NOT NULL REFERENCES warehouses(id),
+        quantity INTEGER NOT NULL CHECK (quantity > 0),
+        reason TEXT NOT NULL,
+        created_by INTEGER REFERENCES users(id),
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        CHECK (from_warehouse_id <> to_warehouse_id)
+    );
+    ALTER TABLE operations
+        ADD COLUMN IF NOT EXISTS warehouse_id INTEGER REFERENCES warehouses(id),
+        ADD COLUMN IF NOT EXISTS warehouse_balance_after INTEGER,
+        ADD COLUMN IF NOT EXISTS transfer_id INTEGER REFERENCES stock_transfers(id)`,
+
+	// 13: bin locations inside warehouses. A warehouse balance is split over
+	// bins, and whatever is in no bin is unassigned stock.
+	`CREATE TABLE IF NOT EXISTS bins (
+        id SERIAL PRIMARY KEY,
+        warehouse_id INTEGER NOT NULL REFERENCES warehouses(id),
+        zone TEXT NOT NULL,
+        aisle TEXT NOT NULL,
+        shelf TEXT NOT NULL,
+        bin TEXT NOT NULL,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS bins_location_key ON bins (warehouse_id, zone, aisle, shelf, bin);
+    CREATE TABLE IF NOT EXISTS bin_stock (
+        product_id INTEGER NOT NULL REFERENCES products(id),
+        bin_id INTEGER NOT NULL REFERENCES bins(id),
+        quantity INTEGER NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+        PRIMARY KEY (product_id, bin_id)
+    );
+    CREATE INDEX IF NOT EXISTS bin_stock_bin_idx ON bin_stock (bin_id);
+    CREATE TABLE IF NOT EXISTS bin_moves (
+        id SERIAL PRIMARY KEY,
+        product_id INTEGER NOT NULL REFERENCES products(id),
+        warehouse_id INTEGER NOT NULL REFERENCES warehouses(id),
+        from_bin_id INTEGER REFERENCES bins(id),
+        to_bin_id INTEGER REFERENCES bins(id),
+        quantity INTEGER NOT NULL CHECK (quantity > 0),
+        reason TEXT NOT NULL,
+        created_by INTEGER REFERENCES users(id),
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        CHECK (from_bin_id IS DISTINCT FROM to_bin_id)
+    );
+    ALTER TABLE operations
+        ADD COLUMN IF NOT EXISTS bin_move_id INTEGER REFERENCES bin_moves(id)`,
+
+	// 14: users provisioned through OIDC are bound to their IdP account, so a
+	// login is never matched to a local account by username or email
+	`ALTER TABLE users
+        ADD COLUMN IF NOT EXISTS oidc_issuer TEXT,
+        ADD COLUMN IF NOT EXISTS oidc_subject TEXT;
+    CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_identity_key ON users (oidc_issuer, oidc_subject)`,
+
+	// 15: a replayed idempotent response keeps the headers a client acts on,
+	// the ETag for its next If-Match and the Location of what it created
+	`ALTER TABLE idempotency_keys
+        ADD COLUMN IF NOT EXISTS etag TEXT,
+        ADD COLUMN IF NOT EXISTS location TEXT`,
+}
+
+// migrate brings the schema up to date
+func migrate() error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %v", err)
+		}
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %v", version, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %v", version, err)
+		}
+		fmt.Println("Applied migration", version)
+	}
+
+	return nil
+}
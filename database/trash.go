@@ -0,0 +1,154 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrProductNotDeleted is returned when restoring a product that is not in the trash
+var ErrProductNotDeleted = errors.New("product is not deleted")
+
+// purgeBatchSize bounds how many products one purge run anonymizes
+const purgeBatchSize = 500
+
+// RestoreProduct takes a product out of the trash, recording the reversal of
+// its deletion, and returns the product's new version
+func RestoreProduct(op models.Operation) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var deleted bool
+	var version int
+	err = tx.QueryRow("SELECT deleted_at IS NOT NULL, version FROM products WHERE id = $1 AND purged_at IS NULL FOR UPDATE",
+		op.ProductID).Scan(&deleted, &version)
+	if err == sql.ErrNoRows {
+		return 0, ErrProductNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	if !deleted {
+		return 0, ErrProductNotDeleted
+	}
+	if err := checkVersion(op, version); err != nil {
+		return 0, err
+	}
+
+	var balance int
+	var unitPrice float64
+	err = tx.QueryRow(`
+        UPDATE products
+        SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING quantity, unit_price, version`,
+		op.ProductID).Scan(&balance, &unitPrice, &version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore product: %v", err)
+	}
+
+	// The restore reverses the latest deletion
+	var deletion int
+	err = tx.QueryRow("SELECT COALESCE(MAX(id), 0) FROM operations WHERE product_id = $1 AND type = $2",
+		op.ProductID, models.OperationDelProduct).Scan(&deletion)
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+
+	entry := ledgerEntry{
+		ProductID: op.ProductID,
+		Type:      models.OperationRestoreProduct,
+		Reason:    reasonOrDefault(op.Reason, "Product restored"),
+		UserID:    op.UserID,
+		ActorID:   op.ActorID,
+		Balance:   balance,
+		UnitCost:  unitPrice,
+		UnitPrice: unitPrice,
+		RevertOf:  deletion,
+	}
+	if err := recordOperation(tx, entry); err != nil {
+		return 0, err
+	}
+
+	return version, tx.Commit()
+}
+
+// PurgeDeletedProducts anonymizes products deleted before cutoff and returns
+// how many were purged. Their rows, stock and ledger entries stay so reports
+// and point-in-time inventory keep adding up; the name, SKU, category and
+// attributes are replaced and the barcodes and purchase units released. The
+// append-only ledger still holds the old values in its field diffs, so those
+// are left out wherever diffs are read once the product is purged.
+func PurgeDeletedProducts(cutoff time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+        SELECT id FROM products
+        WHERE deleted_at < $1 AND purged_at IS NULL
+        ORDER BY deleted_at
+        LIMIT $2
+        FOR UPDATE SKIP LOCKED`, cutoff, purgeBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query deleted products: %v", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan deleted product row: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating deleted product rows: %v", err)
+	}
+
+	for _, id := range ids {
+		if _, err := tx.Exec("DELETE FROM product_barcodes WHERE product_id = $1", id); err != nil {
+			return 0, fmt.Errorf("failed to release barcodes: %v", err)
+		}
+		if _, err := tx.Exec("DELETE FROM product_units WHERE product_id = $1", id); err != nil {
+			return 0, fmt.Errorf("failed to release purchase units: %v", err)
+		}
+
+		var balance int
+		var unitPrice float64
+		err := tx.QueryRow(`
+            UPDATE products
+            SET name = 'Purged product ' || id,
+                sku = 'PURGED-' || id,
+                category_id = NULL,
+                attributes = '{}',
+                purged_at = CURRENT_TIMESTAMP
+            WHERE id = $1
+            RETURNING quantity, unit_price`, id).Scan(&balance, &unitPrice)
+		if err != nil {
+			return 0, fmt.Errorf("failed to purge product %d: %v", id, err)
+		}
+
+		entry := ledgerEntry{
+			ProductID: id,
+			Type:      models.OperationPurgeProduct,
+			Reason:    "Retention period elapsed",
+			Balance:   balance,
+			UnitCost:  unitPrice,
+			UnitPrice: unitPrice,
+		}
+		if err := recordOperation(tx, entry); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), tx.Commit()
+}
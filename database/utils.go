@@ -3,12 +3,43 @@ package database
 import (
 	"casbin-demo/models"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 var db *sql.DB
 
+var (
+	// ErrProductNotFound is returned when a stock change targets an unknown product
+	ErrProductNotFound = errors.New("product not found")
+	// ErrInsufficientStock is returned when a change would take stock below zero
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrVersionMismatch is returned when a product changed since the client read it
+	ErrVersionMismatch = errors.New("product was modified by another request")
+)
+
+// checkVersion rejects a change to a product whose version the client did not expect
+func checkVersion(op models.Operation, version int) error {
+	if len(op.Versions) > 0 && !slices.Contains(op.Versions, version) {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// isCheckViolation reports whether err is a CHECK constraint failure
+func isCheckViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23514"
+}
+
 func CreateUser(username, password string) error {
 	_, err := db.Exec("INSERT INTO users (username, password) VALUES ($1, $2)", username, password)
 	return err
@@ -20,18 +51,205 @@ func GetPasswordForUser(username string) (string, error) {
 	return hashedPassword, err
 }
 
-func GetUserByUsername(username string) (models.User, error) {
+// userColumns is the column list scanned by scanUser
+const userColumns = "id, username, password, COALESCE(email, ''), COALESCE(full_name, ''), status, deleted_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (models.User, error) {
 	var user models.User
-	err := db.QueryRow("SELECT id, username, password FROM users WHERE username=$1 AND deleted_at IS NULL", username).Scan(&user.ID, &user.Username, &user.Password)
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.FullName, &user.Status, &user.DeletedAt)
 	return user, err
 }
 
-func SoftDeleteUser(username string) error {
-	_, err := db.Exec("UPDATE users SET deleted_at = $1 WHERE username = $2 AND deleted_at IS NULL", time.Now(), username)
+func GetUserByUsername(username string) (models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE username=$1 AND deleted_at IS NULL", username))
+}
+
+// SoftDeleteUser marks a user deleted and remembers its groups so they can be restored
+func SoftDeleteUser(username string, groups []string) error {
+	groupsJSON, err := json.Marshal(groups)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE users SET deleted_at = $1, deleted_groups = $2 WHERE username = $3 AND deleted_at IS NULL", time.Now(), string(groupsJSON), username)
 	return err
 }
 
-// AddProductStock increases product stock
+// GetUserByID returns a user by ID, including soft-deleted users
+func GetUserByID(id int) (models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id=$1", id))
+}
+
+// GetDeletedUser returns the most recently soft-deleted user with a username
+func GetDeletedUser(username string) (models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE username=$1 AND deleted_at IS NOT NULL ORDER BY deleted_at DESC LIMIT 1", username))
+}
+
+// GetDeletedGroups returns the groups the most recently soft-deleted user
+// with a username had when deleted
+func GetDeletedGroups(username string) ([]string, error) {
+	var groupsJSON sql.NullString
+	err := db.QueryRow(`
+        SELECT deleted_groups FROM users
+        WHERE username = $1 AND deleted_at IS NOT NULL
+        ORDER BY deleted_at DESC LIMIT 1`, username).Scan(&groupsJSON)
+	if err != nil {
+		return nil, err
+	}
+	return parseDeletedGroups(groupsJSON)
+}
+
+// RestoreUser clears the soft-delete marker of a user and returns the groups it had when deleted
+func RestoreUser(username string) ([]string, error) {
+	var groupsJSON sql.NullString
+	err := db.QueryRow(`
+        WITH target AS (
+            SELECT id, deleted_groups FROM users
+            WHERE username = $1 AND deleted_at IS NOT NULL
+            ORDER BY deleted_at DESC LIMIT 1
+        )
+        UPDATE users u
+        SET deleted_at = NULL, deleted_groups = NULL
+        FROM target
+        WHERE u.id = target.id
+        RETURNING target.deleted_groups`, username).Scan(&groupsJSON)
+	if err != nil {
+		return nil, err
+	}
+	return parseDeletedGroups(groupsJSON)
+}
+
+func parseDeletedGroups(groupsJSON sql.NullString) ([]string, error) {
+	var groups []string
+	if groupsJSON.Valid && groupsJSON.String != "" {
+		if err := json.Unmarshal([]byte(groupsJSON.String), &groups); err != nil {
+			return nil, fmt.Errorf("invalid deleted groups: %v", err)
+		}
+	}
+	return groups, nil
+}
+
+// UpdateUserPassword replaces the password hash of a user
+func UpdateUserPassword(id int, password string) error {
+	_, err := db.Exec("UPDATE users SET password = $1 WHERE id = $2", password, id)
+	return err
+}
+
+// UpdateUserProfile updates the profile fields that are set on the request
+func UpdateUserProfile(username string, req models.UserUpdateRequest) error {
+	query := "UPDATE users SET username = username"
+	params := make([]interface{}, 0)
+
+	if req.Email != nil {
+		params = append(params, *req.Email)
+		query += fmt.Sprintf(", email = $%d", len(params))
+	}
+	if req.FullName != nil {
+		params = append(params, *req.FullName)
+		query += fmt.Sprintf(", full_name = $%d", len(params))
+	}
+	if req.Status != nil {
+		params = append(params, *req.Status)
+		query += fmt.Sprintf(", status = $%d", len(params))
+	}
+
+	params = append(params, username)
+	query += fmt.Sprintf(" WHERE username = $%d AND deleted_at IS NULL", len(params))
+
+	result, err := db.Exec(query, params...)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %v", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListUsers returns a page of users matching the filter, and the total number of matches
+func ListUsers(filter models.UserFilter) ([]models.User, int, error) {
+	where := "WHERE 1=1"
+	params := make([]interface{}, 0)
+
+	if filter.Username != "" {
+		params = append(params, filter.Username)
+		where += fmt.Sprintf(" AND username = $%d", len(params))
+	}
+	if filter.UsernamePrefix != "" {
+		params = append(params, filter.UsernamePrefix+"%")
+		where += fmt.Sprintf(" AND username LIKE $%d", len(params))
+	}
+	if filter.UsernameContains != "" {
+		params = append(params, "%"+filter.UsernameContains+"%")
+		where += fmt.Sprintf(" AND username LIKE $%d", len(params))
+	}
+	if filter.Search != "" {
+		params = append(params, "%"+filter.Search+"%")
+		where += fmt.Sprintf(" AND (username ILIKE $%d OR email ILIKE $%d OR full_name ILIKE $%d)", len(params), len(params), len(params))
+	}
+	if filter.Usernames != nil {
+		params = append(params, pq.Array(filter.Usernames))
+		where += fmt.Sprintf(" AND username = ANY($%d)", len(params))
+	}
+	if filter.Status != "" {
+		params = append(params, filter.Status)
+		where += fmt.Sprintf(" AND status = $%d", len(params))
+	}
+	switch {
+	case filter.OnlyDeleted:
+		where += " AND deleted_at IS NOT NULL"
+	case !filter.IncludeDeleted:
+		where += " AND deleted_at IS NULL"
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users "+where, params...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %v", err)
+	}
+
+	query := "SELECT " + userColumns + " FROM users " + where + " ORDER BY id"
+	if filter.Limit > 0 {
+		params = append(params, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(params))
+	}
+	if filter.Offset > 0 {
+		params = append(params, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(params))
+	}
+
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query users: %v", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan user row: %v", err)
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating user rows: %v", err)
+	}
+
+	return users, total, nil
+}
+
+// AddProductStock increases product stock in the operation's warehouse and
+// puts it away in the operation's bin, if any
 func AddProductStock(op models.Operation) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -39,28 +257,61 @@ func AddProductStock(op models.Operation) error {
 	}
 	defer tx.Rollback()
 
-	result, err := tx.Exec(`
+	warehouseID, err := warehouseOrDefault(tx, op.WarehouseID)
+	if err != nil {
+		return err
+	}
+
+	var balance int
+	var unitPrice float64
+	err = tx.QueryRow(`
         UPDATE products 
         SET quantity = quantity + $1, 
             updated_at = CURRENT_TIMESTAMP
-        WHERE id = $2`, op.Quantity, op.ProductID)
+        WHERE id = $2 AND deleted_at IS NULL AND purged_at IS NULL
+        RETURNING quantity, unit_price`, op.Quantity, op.ProductID).Scan(&balance, &unitPrice)
+	if err == sql.ErrNoRows {
+		return ErrProductNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update stock: %v", err)
 	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("product not found")
+	warehouseBalance, err := changeBalance(tx, op.ProductID, warehouseID, op.Quantity)
+	if err != nil {
+		return err
 	}
 
-	if err := recordOperation(tx, op.ProductID, models.OperationAddStock, "Import stock", op.UserID); err != nil {
+	entry := ledgerEntry{
+		ProductID:        op.ProductID,
+		Type:             models.OperationAddStock,
+		Reason:           reasonOrDefault(op.Reason, "Import stock"),
+		UserID:           op.UserID,
+		ActorID:          op.ActorID,
+		Delta:            op.Quantity,
+		Balance:          balance,
+		UnitCost:         unitPrice,
+		UnitPrice:        unitPrice,
+		WarehouseID:      warehouseID,
+		WarehouseBalance: warehouseBalance,
+	}
+	if op.UnitCost > 0 {
+		entry.UnitCost = op.UnitCost
+	}
+	if err := recordOperation(tx, entry); err != nil {
 		return err
 	}
 
+	if op.BinID != 0 {
+		if _, err := moveBinStock(tx, op, warehouseID, 0, op.BinID, op.Quantity, "Put-away"); err != nil {
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
 
-// RemoveProductStock decreases product stock
+// RemoveProductStock decreases product stock in the operation's warehouse,
+// taking it from the operation's bin or else as pickStock does
 func RemoveProductStock(op models.Operation) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -68,139 +319,439 @@ func RemoveProductStock(op models.Operation) error {
 	}
 	defer tx.Rollback()
 
-	var currentQuantity int
-	err = tx.QueryRow("SELECT quantity FROM products WHERE id = $1", op.ProductID).Scan(&currentQuantity)
+	warehouseID, err := warehouseOrDefault(tx, op.WarehouseID)
+	if err != nil {
+		return err
+	}
+
+	// Concurrent removals serialize on the row lock, so each sees the latest
+	// quantity and takes stock off the shelves before it is removed. Deleted
+	// and purged products take no stock movements.
+	var locked int
+	err = tx.QueryRow("SELECT id FROM products WHERE id = $1 AND deleted_at IS NULL AND purged_at IS NULL FOR UPDATE",
+		op.ProductID).Scan(&locked)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("product not found")
+		return ErrProductNotFound
 	}
 	if err != nil {
 		return fmt.Errorf("database error: %v", err)
 	}
-
-	if currentQuantity < op.Quantity {
-		return fmt.Errorf("insufficient stock")
+	reason := reasonOrDefault(op.Reason, "Deliver stock")
+	if err := pickStock(tx, op, warehouseID, op.BinID, op.Quantity, reason); err != nil {
+		return err
 	}
 
-	_, err = tx.Exec(`
+	var balance int
+	var unitPrice float64
+	err = tx.QueryRow(`
         UPDATE products 
         SET quantity = quantity - $1,
             updated_at = CURRENT_TIMESTAMP
-        WHERE id = $2`, op.Quantity, op.ProductID)
+        WHERE id = $2 AND quantity >= $1
+        RETURNING quantity, unit_price`, op.Quantity, op.ProductID).Scan(&balance, &unitPrice)
+	if err == sql.ErrNoRows {
+		return ErrInsufficientStock
+	}
+	if isCheckViolation(err) {
+		return ErrInsufficientStock
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update stock: %v", err)
 	}
+	// The total can cover the removal while this warehouse cannot
+	warehouseBalance, err := changeBalance(tx, op.ProductID, warehouseID, -op.Quantity)
+	if err != nil {
+		return err
+	}
 
-	if err := recordOperation(tx, op.ProductID, models.OperationRemoveStock, "Deliver stock", op.UserID); err != nil {
+	entry := ledgerEntry{
+		ProductID:        op.ProductID,
+		Type:             models.OperationRemoveStock,
+		Reason:           reason,
+		UserID:           op.UserID,
+		ActorID:          op.ActorID,
+		Delta:            -op.Quantity,
+		Balance:          balance,
+		UnitCost:         unitPrice,
+		UnitPrice:        unitPrice,
+		WarehouseID:      warehouseID,
+		WarehouseBalance: warehouseBalance,
+	}
+	if err := recordOperation(tx, entry); err != nil {
 		return err
 	}
 
 	return tx.Commit()
 }
 
-func UpdateProduct(op models.Operation) error {
+// UpdateProduct applies the fields set on the update, records which fields
+// changed in the ledger and returns the product's new version
+func UpdateProduct(op models.Operation, update models.ProductUpdate) (int, error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
 	}
 	defer tx.Rollback()
 
-	// Build dynamic UPDATE query
+	version, err := updateProduct(tx, op, update, 0)
+	if err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// updateProduct applies an update inside tx and records it in the ledger with
+// a before/after diff of every changed field. revertOf names the operation
+// the update reverts, or is 0.
+func updateProduct(tx *sql.Tx, op models.Operation, update models.ProductUpdate, revertOf int) (int, error) {
+	// The row lock keeps the product from changing until the update is done
+	var locked int
+	err := tx.QueryRow("SELECT id FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", op.ProductID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return 0, ErrProductNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+
+	current, err := scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products p WHERE p.id = $1", op.ProductID))
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	if err := checkVersion(op, current.Version); err != nil {
+		return 0, err
+	}
+
+	// Build dynamic UPDATE query from the fields that actually change
 	query := "UPDATE products SET updated_at = CURRENT_TIMESTAMP"
 	params := make([]interface{}, 0)
-	paramCount := 1
+	var changed []string
+	set := func(column string, value interface{}) {
+		params = append(params, value)
+		query += fmt.Sprintf(", %s = $%d", column, len(params))
+		changed = append(changed, column)
+	}
 
-	if op.Name != "" {
-		query += fmt.Sprintf(", name = $%d", paramCount)
-		params = append(params, op.Name)
-		paramCount++
+	if update.Name != nil && *update.Name != current.Name {
+		set("name", *update.Name)
+	}
+	if update.UnitPrice != nil && *update.UnitPrice != current.UnitPrice {
+		set("unit_price", *update.UnitPrice)
+	}
+	if update.Quantity != nil && *update.Quantity != current.Quantity {
+		set("quantity", *update.Quantity)
+	}
+	if update.SKU != nil && *update.SKU != current.SKU {
+		set("sku", *update.SKU)
+	}
+	if update.CategoryID != nil {
+		var categoryID *int
+		if *update.CategoryID != 0 {
+			categoryID = update.CategoryID
+		}
+		if !reflect.DeepEqual(categoryID, current.CategoryID) {
+			set("category_id", categoryID)
+		}
+	}
+	if update.BaseUnit != nil && *update.BaseUnit != current.BaseUnit {
+		set("base_unit", *update.BaseUnit)
+	}
+	if update.Attributes != nil && !reflect.DeepEqual(attributesOrEmpty(*update.Attributes), current.Attributes) {
+		set("attributes", attributesJSON(*update.Attributes))
 	}
 
-	if op.UnitPrice > 0 {
-		query += fmt.Sprintf(", unit_price = $%d", paramCount)
-		params = append(params, op.UnitPrice)
-		paramCount++
+	var warehouseID, warehouseBalance int
+	quantityChanged := update.Quantity != nil && *update.Quantity != current.Quantity
+	if quantityChanged {
+		if warehouseID, err = warehouseOrDefault(tx, update.WarehouseID); err != nil {
+			return 0, err
+		}
+	}
+
+	barcodesChanged := update.Barcodes != nil && !sameBarcodes(*update.Barcodes, current.Barcodes)
+	if barcodesChanged {
+		changed = append(changed, "barcodes")
+	}
+	unitsChanged := update.PurchaseUnits != nil && !reflect.DeepEqual(unitsOrEmpty(*update.PurchaseUnits), current.PurchaseUnits)
+	if unitsChanged {
+		changed = append(changed, "purchase_units")
 	}
 
-	if op.Quantity >= 0 {
-		query += fmt.Sprintf(", quantity = $%d", paramCount)
-		params = append(params, op.Quantity)
-		paramCount++
+	if len(changed) == 0 {
+		return current.Version, nil
+	}
+
+	if quantityChanged && *update.Quantity < current.Quantity {
+		reason := reasonOrDefault(op.Reason, "Stock adjustment")
+		if err := pickStock(tx, op, warehouseID, 0, current.Quantity-*update.Quantity, reason); err != nil {
+			return 0, err
+		}
 	}
 
-	query += fmt.Sprintf(" WHERE id = $%d", paramCount)
 	params = append(params, op.ProductID)
+	query += fmt.Sprintf(" WHERE id = $%d RETURNING quantity, unit_price, version", len(params))
 
-	result, err := tx.Exec(query, params...)
-	if err != nil {
-		return fmt.Errorf("failed to update product: %v", err)
+	var balance, version int
+	var unitPrice float64
+	if err := tx.QueryRow(query, params...).Scan(&balance, &unitPrice, &version); err != nil {
+		if isCheckViolation(err) {
+			return 0, ErrInsufficientStock
+		}
+		if catalogErr := catalogError(err); catalogErr != nil {
+			return 0, catalogErr
+		}
+		return 0, fmt.Errorf("failed to update product: %v", err)
+	}
+
+	// A quantity adjustment is counted in one warehouse
+	if quantityChanged {
+		if warehouseBalance, err = changeBalance(tx, op.ProductID, warehouseID, balance-current.Quantity); err != nil {
+			return 0, err
+		}
+	}
+	if barcodesChanged {
+		if err := setBarcodes(tx, op.ProductID, *update.Barcodes); err != nil {
+			return 0, err
+		}
+	}
+	if unitsChanged {
+		if err := setPurchaseUnits(tx, op.ProductID, *update.PurchaseUnits); err != nil {
+			return 0, err
+		}
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("product not found")
+	updated, err := scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products p WHERE p.id = $1", op.ProductID))
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
 	}
+	changes := diffProducts(current, updated)
 
-	if err := recordOperation(tx, op.ProductID, models.OperationAdjustProduct, op.Reason, op.UserID); err != nil {
-		return err
+	entry := ledgerEntry{
+		ProductID:        op.ProductID,
+		Type:             models.OperationAdjustProduct,
+		Reason:           op.Reason,
+		UserID:           op.UserID,
+		ActorID:          op.ActorID,
+		Delta:            balance - current.Quantity,
+		Balance:          balance,
+		UnitCost:         unitPrice,
+		UnitPrice:        unitPrice,
+		ChangedFields:    changedFields(changes),
+		Changes:          changes,
+		RevertOf:         revertOf,
+		WarehouseID:      warehouseID,
+		WarehouseBalance: warehouseBalance,
+	}
+	if err := recordOperation(tx, entry); err != nil {
+		return 0, err
 	}
 
-	return tx.Commit()
+	return version, nil
 }
 
-func GetAllProducts() ([]models.Product, error) {
-	query := `SELECT id, name, unit_price, quantity
-              FROM products WHERE deleted_at IS NULL`
-	rows, err := db.Query(query)
+// productSortTypes gives the Postgres type a cursor value is cast back to
+var productSortTypes = map[string]string{
+	"id":         "integer",
+	"sku":        "text",
+	"name":       "text",
+	"unit_price": "numeric",
+	"quantity":   "integer",
+	"created_at": "timestamp",
+	"updated_at": "timestamp",
+}
+
+// GetAllProducts returns a page of products matching the query, sorted on one
+// column with the ID breaking ties, and the total number of matches
+func GetAllProducts(q models.ProductQuery) (models.ProductPage, error) {
+	page := models.ProductPage{Products: []models.Product{}}
+
+	sortColumn := q.Sort
+	if sortColumn == "" {
+		sortColumn = "id"
+	}
+	sortType, ok := productSortTypes[sortColumn]
+	if !ok {
+		return page, fmt.Errorf("cannot sort products by %q", q.Sort)
+	}
+
+	where := "WHERE 1=1"
+	params := make([]interface{}, 0)
+
+	if q.Search != "" {
+		params = append(params, q.Search)
+		where += fmt.Sprintf(" AND search_vector @@ websearch_to_tsquery('simple', $%d)", len(params))
+	}
+	if q.Name != "" {
+		params = append(params, "%"+q.Name+"%")
+		where += fmt.Sprintf(" AND name ILIKE $%d", len(params))
+	}
+	if q.SKU != "" {
+		params = append(params, q.SKU)
+		where += fmt.Sprintf(" AND sku = $%d", len(params))
+	}
+	if q.Barcode != "" {
+		params = append(params, q.Barcode)
+		where += fmt.Sprintf(" AND id IN (SELECT product_id FROM product_barcodes WHERE barcode = $%d)", len(params))
+	}
+	if q.CategoryID != nil {
+		params = append(params, *q.CategoryID)
+		where += fmt.Sprintf(` AND category_id IN (
+            WITH RECURSIVE subtree AS (
+                SELECT id FROM categories WHERE id = $%d
+                UNION ALL
+                SELECT c.id FROM categories c JOIN subtree ON c.parent_id = subtree.id
+            )
+            SELECT id FROM subtree)`, len(params))
+	}
+	keys := make([]string, 0, len(q.Attributes))
+	for key := range q.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var matches []string
+		for _, document := range attributeFilters(key, q.Attributes[key]) {
+			params = append(params, document)
+			matches = append(matches, fmt.Sprintf("attributes @> $%d::jsonb", len(params)))
+		}
+		where += " AND (" + strings.Join(matches, " OR ") + ")"
+	}
+	if q.MinPrice != nil {
+		params = append(params, *q.MinPrice)
+		where += fmt.Sprintf(" AND unit_price >= $%d", len(params))
+	}
+	if q.MaxPrice != nil {
+		params = append(params, *q.MaxPrice)
+		where += fmt.Sprintf(" AND unit_price <= $%d", len(params))
+	}
+	if q.MinQuantity != nil {
+		params = append(params, *q.MinQuantity)
+		where += fmt.Sprintf(" AND quantity >= $%d", len(params))
+	}
+	if q.MaxQuantity != nil {
+		params = append(params, *q.MaxQuantity)
+		where += fmt.Sprintf(" AND quantity <= $%d", len(params))
+	}
+	// Purged products only remain for the history of reports
+	where += " AND purged_at IS NULL"
+	switch {
+	case q.OnlyDeleted:
+		where += " AND deleted_at IS NOT NULL"
+	case !q.IncludeDeleted:
+		where += " AND deleted_at IS NULL"
+	}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM products p "+where, params...).Scan(&page.Total); err != nil {
+		return page, fmt.Errorf("failed to count products: %v", err)
+	}
+
+	direction, compare := "ASC", ">"
+	if q.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	if q.After != nil {
+		params = append(params, q.After.Value, q.After.ID)
+		where += fmt.Sprintf(" AND (p.%s, p.id) %s ($%d::%s, $%d)", sortColumn, compare, len(params)-1, sortType, len(params))
+	}
+
+	query := fmt.Sprintf(`SELECT %s, p.%s::text
+              FROM products p %s ORDER BY p.%s %s, p.id %s`, productColumns, sortColumn, where, sortColumn, direction, direction)
+	if q.Limit > 0 {
+		// One extra row tells whether another page follows
+		params = append(params, q.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(params))
+	}
+
+	rows, err := db.Query(query, params...)
 	if err != nil {
-		return nil, err
+		return page, fmt.Errorf("failed to query products: %v", err)
 	}
 	defer rows.Close()
 
-	var products []models.Product
+	var sortValues []string
 	for rows.Next() {
-		var p models.Product
-		err := rows.Scan(&p.ID, &p.Name, &p.UnitPrice, &p.Quantity)
+		var sortValue string
+		p, err := scanProduct(rows, &sortValue)
 		if err != nil {
-			return nil, err
-
+			return page, fmt.Errorf("failed to scan product row: %v", err)
 		}
-		products = append(products, p)
+		page.Products = append(page.Products, p)
+		sortValues = append(sortValues, sortValue)
 	}
-	return products, nil
+
+	if err := rows.Err(); err != nil {
+		return page, fmt.Errorf("error iterating product rows: %v", err)
+	}
+
+	if q.Limit > 0 && len(page.Products) > q.Limit {
+		page.Products = page.Products[:q.Limit]
+		last := page.Products[q.Limit-1]
+		page.Next = &models.ProductCursor{Sort: sortColumn, Desc: q.Desc, Value: sortValues[q.Limit-1], ID: last.ID}
+	}
+	return page, nil
 }
 
 func GetProductByID(id int) (models.Product, error) {
-	var product models.Product
-	query := `SELECT id, name, unit_price, quantity 
-              FROM products WHERE id = $1 AND deleted_at IS NULL`
-	err := db.QueryRow(query, id).Scan(
-		&product.ID, &product.Name, &product.UnitPrice, &product.Quantity)
-	return product, err
+	return scanProduct(db.QueryRow("SELECT "+productColumns+" FROM products p WHERE p.id = $1 AND p.deleted_at IS NULL", id))
 }
 
-func CreateProduct(op models.Operation) error {
+// CreateProduct adds a product with its master data and opening stock and returns its ID
+func CreateProduct(op models.Operation, details models.ProductRequest) (int, error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
 	}
 	defer tx.Rollback()
 
+	warehouseID, err := warehouseOrDefault(tx, details.WarehouseID)
+	if err != nil {
+		return 0, err
+	}
+
 	var productID int
 	err = tx.QueryRow(`
-        INSERT INTO products (name, unit_price, quantity, created_at, updated_at) 
-        VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+        INSERT INTO products (name, unit_price, quantity, sku, category_id, base_unit, attributes, created_at, updated_at) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
         RETURNING id`,
-		op.Name, op.UnitPrice, op.Quantity).Scan(&productID)
+		details.Name, details.UnitPrice, details.Quantity, details.SKU, details.CategoryID,
+		details.BaseUnit, attributesJSON(details.Attributes)).Scan(&productID)
 	if err != nil {
-		return fmt.Errorf("failed to add product: %v", err)
+		if catalogErr := catalogError(err); catalogErr != nil {
+			return 0, catalogErr
+		}
+		return 0, fmt.Errorf("failed to add product: %v", err)
+	}
+
+	if err := setBarcodes(tx, productID, details.Barcodes); err != nil {
+		return 0, err
+	}
+	if err := setPurchaseUnits(tx, productID, details.PurchaseUnits); err != nil {
+		return 0, err
+	}
+	if _, err := changeBalance(tx, productID, warehouseID, details.Quantity); err != nil {
+		return 0, err
 	}
 
 	// Record the operation
-	if err := recordOperation(tx, productID, op.Type, op.Reason, op.UserID); err != nil {
-		return err
+	entry := ledgerEntry{
+		ProductID:        productID,
+		Type:             op.Type,
+		Reason:           op.Reason,
+		UserID:           op.UserID,
+		ActorID:          op.ActorID,
+		Delta:            details.Quantity,
+		Balance:          details.Quantity,
+		UnitCost:         details.UnitPrice,
+		UnitPrice:        details.UnitPrice,
+		WarehouseID:      warehouseID,
+		WarehouseBalance: details.Quantity,
+	}
+	if err := recordOperation(tx, entry); err != nil {
+		return 0, err
 	}
 
-	return tx.Commit()
+	return productID, tx.Commit()
 }
 
 func DeleteProduct(op models.Operation) error {
@@ -210,26 +761,47 @@ func DeleteProduct(op models.Operation) error {
 	}
 	defer tx.Rollback()
 
-	// Soft delete the product
-	result, err := tx.Exec(`
+	var version int
+	err = tx.QueryRow("SELECT version FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", op.ProductID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	if err := checkVersion(op, version); err != nil {
+		return err
+	}
+
+	// Soft delete the product; its stock stays on the books
+	var balance int
+	var unitPrice float64
+	err = tx.QueryRow(`
         UPDATE products 
         SET deleted_at = CURRENT_TIMESTAMP 
-        WHERE id = $1 AND deleted_at IS NULL`,
-		op.ProductID)
+        WHERE id = $1 AND deleted_at IS NULL
+        RETURNING quantity, unit_price`,
+		op.ProductID).Scan(&balance, &unitPrice)
+	if err == sql.ErrNoRows {
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete product: %v", err)
 	}
 
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get affected rows: %v", err)
+	// Record the operation. It names no warehouse: a deletion moves no stock,
+	// its delta is 0, and the product may be held in several warehouses.
+	entry := ledgerEntry{
+		ProductID: op.ProductID,
+		Type:      op.Type,
+		Reason:    op.Reason,
+		UserID:    op.UserID,
+		ActorID:   op.ActorID,
+		Balance:   balance,
+		UnitCost:  unitPrice,
+		UnitPrice: unitPrice,
 	}
-	if rows == 0 {
-		return sql.ErrNoRows
-	}
-
-	// Record the operation
-	if err := recordOperation(tx, op.ProductID, op.Type, op.Reason, op.UserID); err != nil {
+	if err := recordOperation(tx, entry); err != nil {
 		return err
 	}
 
@@ -244,13 +816,30 @@ func GetProductsReport() ([]models.OperationReport, error) {
             p.name as product_name,
             o.type,
             o.reason,
-            o.created_by,
-            u.username as created_by_username,
+            COALESCE(o.created_by, 0),
+            COALESCE(u.username, 'system') as created_by_username,
+            o.quantity_delta,
+            o.balance_after,
+            o.unit_cost,
+            o.actor_id,
+            a.username as actor_username,
+            o.changed_fields,
+            CASE WHEN p.purged_at IS NULL THEN o.changes END,
+            o.revert_of,
+            o.warehouse_id,
+            o.warehouse_balance_after,
+            o.transfer_id,
+            o.bin_move_id,
+            m.from_bin_id,
+            m.to_bin_id,
+            m.quantity,
             o.created_at
         FROM operations o
         JOIN products p ON o.product_id = p.id
-        JOIN users u ON o.created_by = u.id
-        ORDER BY o.created_at DESC`
+        LEFT JOIN bin_moves m ON o.bin_move_id = m.id
+        LEFT JOIN users u ON o.created_by = u.id
+        LEFT JOIN users a ON o.actor_id = a.id
+        ORDER BY o.created_at DESC, o.id DESC`
 
 	rows, err := db.Query(query)
 	if err != nil {
@@ -261,6 +850,7 @@ func GetProductsReport() ([]models.OperationReport, error) {
 	var reports []models.OperationReport
 	for rows.Next() {
 		var report models.OperationReport
+		var changes []byte
 		err := rows.Scan(
 			&report.ID,
 			&report.ProductID,
@@ -269,11 +859,29 @@ func GetProductsReport() ([]models.OperationReport, error) {
 			&report.Reason,
 			&report.CreatedBy,
 			&report.CreatedByUsername,
+			&report.QuantityDelta,
+			&report.BalanceAfter,
+			&report.UnitCost,
+			&report.ActorID,
+			&report.ActorUsername,
+			pq.Array(&report.ChangedFields),
+			&changes,
+			&report.RevertOf,
+			&report.WarehouseID,
+			&report.WarehouseBalanceAfter,
+			&report.TransferID,
+			&report.BinMoveID,
+			&report.FromBinID,
+			&report.ToBinID,
+			&report.MovedQuantity,
 			&report.CreatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan operation row: %v", err)
 		}
+		if report.Changes, err = decodeChanges(changes); err != nil {
+			return nil, err
+		}
 		reports = append(reports, report)
 	}
 
@@ -284,14 +892,152 @@ func GetProductsReport() ([]models.OperationReport, error) {
 	return reports, nil
 }
 
-// recordOperation records an operation in the database
-func recordOperation(tx *sql.Tx, productID int, opType models.OperationType, reason string, userID int) error {
+// ledgerEntry is one row of the operations ledger
+type ledgerEntry struct {
+	ProductID int
+	Type      models.OperationType
+	Reason    string
+	// UserID is 0 for entries made by the system, such as purges
+	UserID int
+	// ActorID is the impersonating user, or 0 when UserID acted for themselves
+	ActorID int
+	// Delta is the signed change in stock and Balance the quantity after it
+	Delta    int
+	Balance  int
+	UnitCost float64
+	// UnitPrice is the product's price after the operation
+	UnitPrice float64
+	// ChangedFields names the product fields an adjustment changed, and
+	// Changes holds their values before and after
+	ChangedFields []string
+	Changes       map[string]models.FieldChange
+	// RevertOf is the operation this entry reverts, or 0
+	RevertOf int
+	// WarehouseID is the warehouse whose stock changed, or 0 when none did,
+	// and WarehouseBalance its quantity after the change
+	WarehouseID      int
+	WarehouseBalance int
+	// TransferID links the two entries of a stock transfer
+	TransferID int
+	// BinMoveID is the bin move a BIN_MOVE entry records
+	BinMoveID int
+}
+
+// recordOperation appends an entry to the operations ledger
+func recordOperation(tx *sql.Tx, entry ledgerEntry) error {
+	var changes interface{}
+	if entry.Changes != nil {
+		data, err := json.Marshal(entry.Changes)
+		if err != nil {
+			return fmt.Errorf("failed to encode changes: %v", err)
+		}
+		changes = string(data)
+	}
+
+	var warehouseBalance interface{}
+	if entry.WarehouseID != 0 {
+		warehouseBalance = entry.WarehouseBalance
+	}
+
 	_, err := tx.Exec(`
-        INSERT INTO operations (product_id, type, reason, created_by)
-        VALUES ($1, $2, $3, $4)`,
-		productID, opType, reason, userID)
+        INSERT INTO operations (product_id, type, reason, created_by, actor_id, quantity_delta, balance_after, unit_cost, unit_price,
+                                changed_fields, changes, revert_of, warehouse_id, warehouse_balance_after, transfer_id, bin_move_id)
+        VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, 0), $6, $7, $8, $9, $10, $11, NULLIF($12, 0), NULLIF($13, 0), $14, NULLIF($15, 0), NULLIF($16, 0))`,
+		entry.ProductID, entry.Type, entry.Reason, entry.UserID, entry.ActorID, entry.Delta, entry.Balance, entry.UnitCost, entry.UnitPrice,
+		pq.Array(entry.ChangedFields), changes, entry.RevertOf, entry.WarehouseID, warehouseBalance, entry.TransferID, entry.BinMoveID)
 	if err != nil {
 		return fmt.Errorf("failed to record operation: %v", err)
 	}
 	return nil
 }
+
+func reasonOrDefault(reason, defaultReason string) string {
+	if reason == "" {
+		return defaultReason
+	}
+	return reason
+}
+
+// ReconcileStock compares every product's quantity, and its balance in each
+// warehouse, with the sum of its ledger deltas and returns the ones that drifted
+func ReconcileStock() ([]models.StockDrift, error) {
+	rows, err := db.Query(`
+        SELECT p.id, p.name, p.quantity, COALESCE(l.total, 0), l.last_balance
+        FROM products p
+        LEFT JOIN (
+            SELECT product_id,
+                   SUM(quantity_delta) AS total,
+                   (ARRAY_AGG(balance_after ORDER BY created_at DESC, id DESC))[1] AS last_balance
+            FROM operations
+            GROUP BY product_id
+        ) l ON l.product_id = p.id
+        WHERE p.quantity <> COALESCE(l.total, 0)
+           OR p.quantity IS DISTINCT FROM COALESCE(l.last_balance, p.quantity)
+        ORDER BY p.id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile stock: %v", err)
+	}
+	defer rows.Close()
+
+	drifts := []models.StockDrift{}
+	for rows.Next() {
+		var drift models.StockDrift
+		if err := rows.Scan(&drift.ProductID, &drift.ProductName, &drift.Quantity, &drift.LedgerTotal, &drift.LastBalance); err != nil {
+			return nil, fmt.Errorf("failed to scan reconciliation row: %v", err)
+		}
+		drift.Drift = drift.Quantity - drift.LedgerTotal
+		drifts = append(drifts, drift)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reconciliation rows: %v", err)
+	}
+
+	warehouseDrifts, err := reconcileWarehouseStock()
+	if err != nil {
+		return nil, err
+	}
+	return append(drifts, warehouseDrifts...), nil
+}
+
+// reconcileWarehouseStock compares every warehouse balance with the sum of
+// the ledger deltas made in that warehouse. Entries from before warehouses
+// existed count towards the default warehouse.
+func reconcileWarehouseStock() ([]models.StockDrift, error) {
+	rows, err := db.Query(`
+        WITH main AS (SELECT id FROM warehouses WHERE is_default)
+        SELECT b.product_id, p.name, b.warehouse_id, b.quantity, COALESCE(l.total, 0), l.last_balance
+        FROM stock_balances b
+        JOIN products p ON p.id = b.product_id
+        LEFT JOIN (
+            SELECT o.product_id,
+                   COALESCE(o.warehouse_id, main.id) AS warehouse_id,
+                   SUM(o.quantity_delta) AS total,
+                   (ARRAY_AGG(o.warehouse_balance_after ORDER BY o.created_at DESC, o.id DESC)
+                       FILTER (WHERE o.warehouse_balance_after IS NOT NULL))[1] AS last_balance
+            FROM operations o, main
+            GROUP BY o.product_id, COALESCE(o.warehouse_id, main.id)
+        ) l ON l.product_id = b.product_id AND l.warehouse_id = b.warehouse_id
+        WHERE b.quantity <> COALESCE(l.total, 0)
+           OR b.quantity IS DISTINCT FROM COALESCE(l.last_balance, b.quantity)
+        ORDER BY b.product_id, b.warehouse_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile warehouse stock: %v", err)
+	}
+	defer rows.Close()
+
+	drifts := []models.StockDrift{}
+	for rows.Next() {
+		var drift models.StockDrift
+		if err := rows.Scan(&drift.ProductID, &drift.ProductName, &drift.WarehouseID, &drift.Quantity, &drift.LedgerTotal, &drift.LastBalance); err != nil {
+			return nil, fmt.Errorf("failed to scan reconciliation row: %v", err)
+		}
+		drift.Drift = drift.Quantity - drift.LedgerTotal
+		drifts = append(drifts, drift)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reconciliation rows: %v", err)
+	}
+	return drifts, nil
+}
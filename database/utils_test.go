@@ -0,0 +1,51 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+
+	"casbin-demo/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDB(conn)
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+		SetDB(nil)
+	})
+	return mock
+}
+
+func TestStockMovementsRefuseDeletedProducts(t *testing.T) {
+	op := models.Operation{ProductID: 3, WarehouseID: 1, Quantity: 2, UserID: 7}
+
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE products .* WHERE id = \\$2 AND deleted_at IS NULL AND purged_at IS NULL").
+		WithArgs(2, 3).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	if err := AddProductStock(op); err != ErrProductNotFound {
+		t.Errorf("AddProductStock on a deleted product = %v, want ErrProductNotFound", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM products WHERE id = \\$1 AND deleted_at IS NULL AND purged_at IS NULL FOR UPDATE").
+		WithArgs(3).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	if err := RemoveProductStock(op); err != ErrProductNotFound {
+		t.Errorf("RemoveProductStock on a deleted product = %v, want ErrProductNotFound", err)
+	}
+}
@@ -0,0 +1,311 @@
+package database
+
+import (
+	"casbin-demo/models"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrWarehouseNotFound  = errors.New("warehouse not found")
+	ErrDuplicateWarehouse = errors.New("another warehouse already has this code")
+)
+
+const warehouseColumns = "id, code, name, is_default, created_at"
+
+func scanWarehouse(row rowScanner) (models.Warehouse, error) {
+	var warehouse models.Warehouse
+	err := row.Scan(&warehouse.ID, &warehouse.Code, &warehouse.Name, &warehouse.IsDefault, &warehouse.CreatedAt)
+	return warehouse, err
+}
+
+// ListWarehouses returns every warehouse, the default one first
+func ListWarehouses() ([]models.Warehouse, error) {
+	rows, err := db.Query("SELECT " + warehouseColumns + " FROM warehouses ORDER BY is_default DESC, code")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query warehouses: %v", err)
+	}
+	defer rows.Close()
+
+	warehouses := []models.Warehouse{}
+	for rows.Next() {
+		warehouse, err := scanWarehouse(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan warehouse row: %v", err)
+		}
+		warehouses = append(warehouses, warehouse)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating warehouse rows: %v", err)
+	}
+	return warehouses, nil
+}
+
+// GetWarehouse returns a warehouse by ID, or the default warehouse for ID 0
+func GetWarehouse(id int) (models.Warehouse, error) {
+	row := db.QueryRow("SELECT "+warehouseColumns+" FROM warehouses WHERE id = $1", id)
+	if id == 0 {
+		row = db.QueryRow("SELECT " + warehouseColumns + " FROM warehouses WHERE is_default")
+	}
+
+	warehouse, err := scanWarehouse(row)
+	if err == sql.ErrNoRows {
+		return warehouse, ErrWarehouseNotFound
+	}
+	if err != nil {
+		return warehouse, fmt.Errorf("failed to read warehouse: %v", err)
+	}
+	return warehouse, nil
+}
+
+func CreateWarehouse(req models.WarehouseRequest) (models.Warehouse, error) {
+	warehouse, err := scanWarehouse(db.QueryRow(
+		"INSERT INTO warehouses (code, name) VALUES ($1, $2) RETURNING "+warehouseColumns, req.Code, req.Name))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "warehouses_code_key" {
+			return warehouse, ErrDuplicateWarehouse
+		}
+		return warehouse, fmt.Errorf("failed to create warehouse: %v", err)
+	}
+	return warehouse, nil
+}
+
+// warehouseOrDefault returns warehouseID, or the default warehouse's ID when it is 0
+func warehouseOrDefault(tx *sql.Tx, warehouseID int) (int, error) {
+	if warehouseID != 0 {
+		return warehouseID, nil
+	}
+	if err := tx.QueryRow("SELECT id FROM warehouses WHERE is_default").Scan(&warehouseID); err != nil {
+		return 0, fmt.Errorf("failed to read default warehouse: %v", err)
+	}
+	return warehouseID, nil
+}
+
+// changeBalance changes a product's balance in a warehouse by delta and returns
+// the new balance. It does not touch products.quantity; callers change the
+// total in the same transaction, before the balance, so the product row lock
+// orders concurrent changes.
+func changeBalance(tx *sql.Tx, productID, warehouseID, delta int) (int, error) {
+	var balance int
+	var err error
+	if delta >= 0 {
+		err = tx.QueryRow(`
+            INSERT INTO stock_balances (product_id, warehouse_id, quantity)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (product_id, warehouse_id)
+            DO UPDATE SET quantity = stock_balances.quantity + EXCLUDED.quantity
+            RETURNING quantity`, productID, warehouseID, delta).Scan(&balance)
+	} else {
+		err = tx.QueryRow(`
+            UPDATE stock_balances
+            SET quantity = quantity + $3
+            WHERE product_id = $1 AND warehouse_id = $2 AND quantity >= -$3
+            RETURNING quantity`, productID, warehouseID, delta).Scan(&balance)
+	}
+
+	if err == sql.ErrNoRows || isCheckViolation(err) {
+		return 0, ErrInsufficientStock
+	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "stock_balances_warehouse_id_fkey" {
+		return 0, ErrWarehouseNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update warehouse stock: %v", err)
+	}
+	return balance, nil
+}
+
+// GetProductStock returns a product's balance in every warehouse that has
+// held it, split over the warehouse's bins
+func GetProductStock(productID int) ([]models.StockBalance, error) {
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)", productID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+
+	rows, err := db.Query(`
+        SELECT w.id, w.code, b.quantity
+        FROM stock_balances b
+        JOIN warehouses w ON w.id = b.warehouse_id
+        WHERE b.product_id = $1
+        ORDER BY w.is_default DESC, w.code`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stock balances: %v", err)
+	}
+	defer rows.Close()
+
+	balances := []models.StockBalance{}
+	for rows.Next() {
+		var balance models.StockBalance
+		if err := rows.Scan(&balance.WarehouseID, &balance.WarehouseCode, &balance.Quantity); err != nil {
+			return nil, fmt.Errorf("failed to scan stock balance row: %v", err)
+		}
+		balances = append(balances, balance)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating stock balance rows: %v", err)
+	}
+
+	for i := range balances {
+		bins, err := productBins(db, productID, balances[i].WarehouseID)
+		if err != nil {
+			return nil, err
+		}
+		balances[i].Bins = bins
+		balances[i].Unassigned = balances[i].Quantity
+		for _, bin := range bins {
+			balances[i].Unassigned -= bin.Quantity
+		}
+	}
+	return balances, nil
+}
+
+// GetWarehouseStock returns the products in stock in a warehouse, valued at
+// their current unit price
+func GetWarehouseStock(warehouse models.Warehouse) (models.WarehouseStockReport, error) {
+	report := models.WarehouseStockReport{Warehouse: warehouse, Items: []models.WarehouseStockItem{}}
+
+	rows, err := db.Query(`
+        SELECT p.id, p.sku, p.name, b.quantity, p.unit_price
+        FROM stock_balances b
+        JOIN products p ON p.id = b.product_id
+        WHERE b.warehouse_id = $1 AND b.quantity > 0 AND p.deleted_at IS NULL
+        ORDER BY p.id`, warehouse.ID)
+	if err != nil {
+		return report, fmt.Errorf("failed to query warehouse stock: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.WarehouseStockItem
+		if err := rows.Scan(&item.ProductID, &item.SKU, &item.Name, &item.Quantity, &item.UnitPrice); err != nil {
+			return report, fmt.Errorf("failed to scan warehouse stock row: %v", err)
+		}
+		item.Value = float64(item.Quantity) * item.UnitPrice
+		report.Items = append(report.Items, item)
+		report.TotalQuantity += item.Quantity
+		report.TotalValue += item.Value
+	}
+
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("error iterating warehouse stock rows: %v", err)
+	}
+	return report, nil
+}
+
+// GetWarehouseSummaries totals the stock in every warehouse
+func GetWarehouseSummaries() ([]models.WarehouseSummary, error) {
+	rows, err := db.Query(`
+        SELECT w.id, w.code, w.name, w.is_default, w.created_at,
+               COUNT(p.id),
+               COALESCE(SUM(b.quantity) FILTER (WHERE p.id IS NOT NULL), 0),
+               COALESCE(SUM(b.quantity * p.unit_price), 0)
+        FROM warehouses w
+        LEFT JOIN stock_balances b ON b.warehouse_id = w.id AND b.quantity > 0
+        LEFT JOIN products p ON p.id = b.product_id AND p.deleted_at IS NULL
+        GROUP BY w.id
+        ORDER BY w.is_default DESC, w.code`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query warehouse stock: %v", err)
+	}
+	defer rows.Close()
+
+	summaries := []models.WarehouseSummary{}
+	for rows.Next() {
+		var summary models.WarehouseSummary
+		w := &summary.Warehouse
+		err := rows.Scan(&w.ID, &w.Code, &w.Name, &w.IsDefault, &w.CreatedAt,
+			&summary.Products, &summary.TotalQuantity, &summary.TotalValue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan warehouse summary row: %v", err)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating warehouse summary rows: %v", err)
+	}
+	return summaries, nil
+}
+
+// TransferStock moves stock of a product between two warehouses. The product's
+// total is unchanged; the ledger gets a TRANSFER_OUT and a TRANSFER_IN entry
+// that point at the same transfer.
+func TransferStock(op models.Operation, req models.TransferRequest) (models.StockTransfer, error) {
+	transfer := models.StockTransfer{
+		ProductID:       op.ProductID,
+		FromWarehouseID: req.FromWarehouseID,
+		ToWarehouseID:   req.ToWarehouseID,
+		Quantity:        req.Quantity,
+		Reason:          reasonOrDefault(req.Reason, "Stock transfer"),
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return transfer, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// The product row lock serializes transfers with every other stock change
+	var total int
+	var unitPrice float64
+	err = tx.QueryRow("SELECT quantity, unit_price FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE",
+		op.ProductID).Scan(&total, &unitPrice)
+	if err == sql.ErrNoRows {
+		return transfer, ErrProductNotFound
+	}
+	if err != nil {
+		return transfer, fmt.Errorf("database error: %v", err)
+	}
+
+	// Stock leaves the source warehouse from its shelves; it arrives unassigned
+	if err := pickStock(tx, op, req.FromWarehouseID, 0, req.Quantity, transfer.Reason); err != nil {
+		return transfer, err
+	}
+	fromBalance, err := changeBalance(tx, op.ProductID, req.FromWarehouseID, -req.Quantity)
+	if err != nil {
+		return transfer, err
+	}
+	toBalance, err := changeBalance(tx, op.ProductID, req.ToWarehouseID, req.Quantity)
+	if err != nil {
+		return transfer, err
+	}
+
+	err = tx.QueryRow(`
+        INSERT INTO stock_transfers (product_id, from_warehouse_id, to_warehouse_id, quantity, reason, created_by)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0))
+        RETURNING id, created_at`,
+		op.ProductID, req.FromWarehouseID, req.ToWarehouseID, req.Quantity, transfer.Reason, op.UserID).
+		Scan(&transfer.ID, &transfer.CreatedAt)
+	if err != nil {
+		return transfer, fmt.Errorf("failed to record transfer: %v", err)
+	}
+
+	entries := []ledgerEntry{
+		{Type: models.OperationTransferOut, Delta: -req.Quantity, WarehouseID: req.FromWarehouseID, WarehouseBalance: fromBalance},
+		{Type: models.OperationTransferIn, Delta: req.Quantity, WarehouseID: req.ToWarehouseID, WarehouseBalance: toBalance},
+	}
+	for _, entry := range entries {
+		entry.ProductID = op.ProductID
+		entry.Reason = transfer.Reason
+		entry.UserID = op.UserID
+		entry.ActorID = op.ActorID
+		entry.Balance = total
+		entry.UnitCost = unitPrice
+		entry.UnitPrice = unitPrice
+		entry.TransferID = transfer.ID
+		if err := recordOperation(tx, entry); err != nil {
+			return transfer, err
+		}
+	}
+
+	return transfer, tx.Commit()
+}
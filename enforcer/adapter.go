@@ -0,0 +1,95 @@
+package enforcer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+)
+
+// PolicyAdapter is the casbin file adapter with optional trailing policy
+// columns: rows shorter than the policy definition are padded with empty
+// values when loaded, and empty trailing values are dropped when saved.
+// This keeps rules written before the condition columns existed valid.
+type PolicyAdapter struct {
+	*fileadapter.Adapter
+	path string
+}
+
+// NewPolicyAdapter creates an adapter for a policy CSV file
+func NewPolicyAdapter(path string) *PolicyAdapter {
+	return &PolicyAdapter{Adapter: fileadapter.NewAdapter(path), path: path}
+}
+
+// LoadPolicy loads every rule from the file, padding short p rules
+func (a *PolicyAdapter) LoadPolicy(m model.Model) error {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		reader := csv.NewReader(strings.NewReader(line))
+		reader.TrimLeadingSpace = true
+		tokens, err := reader.Read()
+		if err != nil {
+			return err
+		}
+		if len(tokens) < 2 {
+			return errors.New("invalid policy line: " + line)
+		}
+
+		if assertion, ok := m["p"][tokens[0]]; ok {
+			tokens = append(tokens[:1], PadRule(tokens[1:], len(assertion.Tokens))...)
+		}
+		if err := persist.LoadPolicyArray(tokens, m); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// SavePolicy writes every rule to the file without empty trailing values
+func (a *PolicyAdapter) SavePolicy(m model.Model) error {
+	var lines []string
+	for _, sec := range []string{"p", "g"} {
+		for ptype, assertion := range m[sec] {
+			for _, rule := range assertion.Policy {
+				lines = append(lines, ptype+", "+strings.Join(TrimRule(rule), ", "))
+			}
+		}
+	}
+
+	return os.WriteFile(a.path, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+// PadRule extends rule with empty values up to size fields
+func PadRule(rule []string, size int) []string {
+	if len(rule) >= size {
+		return rule
+	}
+	padded := make([]string, size)
+	copy(padded, rule)
+	return padded
+}
+
+// TrimRule drops empty trailing values from rule
+func TrimRule(rule []string) []string {
+	end := len(rule)
+	for end > 0 && rule[end-1] == "" {
+		end--
+	}
+	return rule[:end]
+}
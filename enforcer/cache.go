@@ -0,0 +1,82 @@
+package enforcer
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DecisionCache is a fixed-size LRU of Enforce results keyed by request
+type DecisionCache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+	// generation changes on every Clear, so a decision computed before a
+	// policy change is never stored after it
+	generation uint64
+}
+
+type cacheEntry struct {
+	key     string
+	allowed bool
+}
+
+// NewDecisionCache creates a cache holding up to capacity decisions
+func NewDecisionCache(capacity int) *DecisionCache {
+	return &DecisionCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns a cached decision and the generation it was looked up in
+func (c *DecisionCache) Get(key string) (allowed bool, found bool, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*cacheEntry).allowed, true, c.generation
+	}
+	return false, false, c.generation
+}
+
+// Put stores a decision unless the cache was cleared since generation
+func (c *DecisionCache) Put(key string, allowed bool, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.capacity <= 0 || generation != c.generation {
+		return
+	}
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheEntry).allowed = allowed
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, allowed: allowed})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Clear drops every cached decision
+func (c *DecisionCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	c.generation++
+}
+
+// Len returns the number of cached decisions
+func (c *DecisionCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
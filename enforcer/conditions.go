@@ -0,0 +1,196 @@
+package enforcer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// conditionSeparator separates alternatives inside a condition column,
+	// since the policy file uses commas between columns
+	conditionSeparator = "|"
+
+	timeLayout = "15:04"
+)
+
+var weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// Environment is the request context that conditional rules are checked against
+type Environment struct {
+	IP   string
+	Time time.Time
+}
+
+// values returns the environment as the ip, time and day request values.
+// A zero environment yields empty values, which match no condition.
+func (env Environment) values() []interface{} {
+	if env.Time.IsZero() {
+		return []interface{}{env.IP, "", ""}
+	}
+	return []interface{}{env.IP, env.Time.Format(timeLayout), env.Time.Format("Mon")}
+}
+
+// EnforceIn decides a request made in env. Enforce without an environment
+// leaves every conditional rule out.
+func (e *Enforcer) EnforceIn(env Environment, sub, obj, act string) (bool, error) {
+	return e.Enforce(append([]interface{}{sub, obj, act}, env.values()...)...)
+}
+
+// EnforceExIn is EnforceIn that also returns the rule that decided the request
+func (e *Enforcer) EnforceExIn(env Environment, sub, obj, act string) (bool, []string, error) {
+	return e.EnforceEx(append([]interface{}{sub, obj, act}, env.values()...)...)
+}
+
+// HasConditions reports whether a p rule carries a cidr, hours or days condition
+func HasConditions(rule []string) bool {
+	for _, value := range rule[min(len(rule), 4):] {
+		if value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// IPInRange reports whether ip is in any of the | separated CIDRs or addresses.
+// An empty or * cidr matches any request.
+func IPInRange(ip, cidrs string) bool {
+	if cidrs == "" || cidrs == "*" {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, cidr := range strings.Split(cidrs, conditionSeparator) {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if other := net.ParseIP(cidr); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// TimeInWindow reports whether a request at clock time (HH:MM) on day (Mon..Sun)
+// falls in the hours window, e.g. 07:00-19:00, and on one of the days, e.g.
+// Mon-Fri or Sat|Sun. Empty or * hours and days match any request.
+func TimeInWindow(clock, day, hours, days string) bool {
+	if !inDays(day, days) {
+		return false
+	}
+	if hours == "" || hours == "*" {
+		return true
+	}
+
+	now, err := time.Parse(timeLayout, clock)
+	if err != nil {
+		return false
+	}
+	for _, window := range strings.Split(hours, conditionSeparator) {
+		from, to, err := parseHours(window)
+		if err != nil {
+			continue
+		}
+		// A window such as 22:00-06:00 wraps past midnight
+		if from.Before(to) && !now.Before(from) && now.Before(to) {
+			return true
+		}
+		if !from.Before(to) && (!now.Before(from) || now.Before(to)) {
+			return true
+		}
+	}
+	return false
+}
+
+func inDays(day, days string) bool {
+	if days == "" || days == "*" {
+		return true
+	}
+
+	today := weekdayIndex(day)
+	if today < 0 {
+		return false
+	}
+	for _, span := range strings.Split(days, conditionSeparator) {
+		first, last, err := parseDays(span)
+		if err != nil {
+			continue
+		}
+		if first <= last && today >= first && today <= last {
+			return true
+		}
+		// A span such as Fri-Mon wraps past the end of the week
+		if first > last && (today >= first || today <= last) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHours(window string) (time.Time, time.Time, error) {
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("hours %q must look like 07:00-19:00", window)
+	}
+	start, err := time.Parse(timeLayout, strings.TrimSpace(from))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start time in hours %q", window)
+	}
+	end, err := time.Parse(timeLayout, strings.TrimSpace(to))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end time in hours %q", window)
+	}
+	return start, end, nil
+}
+
+func parseDays(span string) (int, int, error) {
+	from, to, isRange := strings.Cut(span, "-")
+	first := weekdayIndex(strings.TrimSpace(from))
+	last := first
+	if isRange {
+		last = weekdayIndex(strings.TrimSpace(to))
+	}
+	if first < 0 || last < 0 {
+		return 0, 0, fmt.Errorf("days %q must use Mon, Tue, Wed, Thu, Fri, Sat or Sun", span)
+	}
+	return first, last, nil
+}
+
+func weekdayIndex(day string) int {
+	for i, name := range weekdays {
+		if strings.EqualFold(name, day) {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateCondition checks the format of a cidr, hours or days column
+func validateCondition(field, value string) error {
+	if value == "" || value == "*" {
+		return nil
+	}
+
+	for _, part := range strings.Split(value, conditionSeparator) {
+		switch field {
+		case "cidr":
+			if _, _, err := net.ParseCIDR(part); err != nil && net.ParseIP(part) == nil {
+				return fmt.Errorf("invalid cidr %q", part)
+			}
+		case "hours":
+			if _, _, err := parseHours(part); err != nil {
+				return err
+			}
+		case "days":
+			if _, _, err := parseDays(part); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -2,39 +2,114 @@ package enforcer
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"sync"
 
 	"github.com/casbin/casbin/v2"
 )
 
 var (
 	// GlobalEnforcer is the global enforcer instance
-	GlobalEnforcer *casbin.Enforcer
+	GlobalEnforcer *Enforcer
+
+	// mu guards GlobalEnforcer, which Reload swaps while requests use it
+	mu sync.RWMutex
+)
+
+const (
+	DefaultModelPath  = "./config/pbac_model.conf"
+	DefaultPolicyPath = "./config/policy.csv"
+
+	// DefaultDecisionCacheSize is used when DECISION_CACHE_SIZE is not set
+	DefaultDecisionCacheSize = 10000
 )
 
 // Initialize creates a new enforcer instance
 func InitializeEnforcer() error {
-	var err error
-	GlobalEnforcer, err = casbin.NewEnforcer("./config/pbac_model.conf", "./config/policy.csv")
+	e, err := NewEnforcer(ModelPath(), PolicyPath())
 	if err != nil {
-		return fmt.Errorf("failed to create enforcer: %w", err)
+		return err
+	}
+
+	mu.Lock()
+	GlobalEnforcer = e
+	mu.Unlock()
+
+	fmt.Println("Enforcer initialized successfully")
+	return nil
+}
+
+// GetEnforcer returns the global enforcer instance
+func GetEnforcer() *Enforcer {
+	mu.RLock()
+	defer mu.RUnlock()
+	return GlobalEnforcer
+}
+
+// ModelPath returns the model file in use, from CASBIN_MODEL_PATH if set
+func ModelPath() string {
+	if path := os.Getenv("CASBIN_MODEL_PATH"); path != "" {
+		return path
 	}
+	return DefaultModelPath
+}
 
-	// GlobalEnforcer.AddFunction("my_key_match", func(args ...interface{}) (interface{}, error) {
+// PolicyPath returns the policy file in use, from CASBIN_POLICY_PATH if set
+func PolicyPath() string {
+	if path := os.Getenv("CASBIN_POLICY_PATH"); path != "" {
+		return path
+	}
+	return DefaultPolicyPath
+}
+
+// DecisionCacheSize returns the decision cache capacity, from DECISION_CACHE_SIZE
+// if set. Zero disables the cache.
+func DecisionCacheSize() int {
+	if size, err := strconv.Atoi(os.Getenv("DECISION_CACHE_SIZE")); err == nil && size >= 0 {
+		return size
+	}
+	return DefaultDecisionCacheSize
+}
+
+// NewEnforcer creates an enforcer for a model and policy file and loads the policy
+func NewEnforcer(modelPath, policyPath string) (*Enforcer, error) {
+	synced, err := casbin.NewSyncedEnforcer(modelPath, NewPolicyAdapter(policyPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enforcer: %w", err)
+	}
+
+	e := &Enforcer{SyncedEnforcer: synced, cache: NewDecisionCache(DecisionCacheSize())}
+	if assertion, ok := synced.GetModel()["r"]["r"]; ok {
+		e.requestSize = len(assertion.Tokens)
+	}
+	if err := e.SetWatcher(&cacheWatcher{cache: e.cache}); err != nil {
+		return nil, fmt.Errorf("failed to set policy watcher: %w", err)
+	}
+
+	e.AddFunction("ipInRange", func(args ...interface{}) (interface{}, error) {
+		ip, _ := args[0].(string)
+		cidrs, _ := args[1].(string)
+		return IPInRange(ip, cidrs), nil
+	})
+	e.AddFunction("timeInWindow", func(args ...interface{}) (interface{}, error) {
+		clock, _ := args[0].(string)
+		day, _ := args[1].(string)
+		hours, _ := args[2].(string)
+		days, _ := args[3].(string)
+		return TimeInWindow(clock, day, hours, days), nil
+	})
+
+	// e.AddFunction("my_key_match", func(args ...interface{}) (interface{}, error) {
 	// 	key1 := args[0].(string)
 	// 	key2 := args[1].(string)
 	// 	return CustomKeyMatch(key1, key2), nil
 	// })
 
 	// Load the policy from CSV file
-	if err := GlobalEnforcer.LoadPolicy(); err != nil {
-		return fmt.Errorf("failed to load policy: %w", err)
+	if err := e.LoadPolicy(); err != nil {
+		return nil, fmt.Errorf("failed to load policy: %w", err)
 	}
 
-	fmt.Println("Enforcer initialized successfully")
-	return nil
-}
-
-// GetEnforcer returns the global enforcer instance
-func GetEnforcer() *casbin.Enforcer {
-	return GlobalEnforcer
+	return e, nil
 }
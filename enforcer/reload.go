@@ -0,0 +1,68 @@
+package enforcer
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadMu serializes reloads so two triggers cannot race on the swap, and
+// holds them off while a policy change is being saved
+var reloadMu sync.Mutex
+
+// LockForUpdate returns the running enforcer and holds off reloads until
+// unlock is called. Take it to change and save the policy; otherwise a reload
+// between the two could swap in a new enforcer and the save would land on the
+// discarded one.
+func LockForUpdate() (e *Enforcer, unlock func()) {
+	reloadMu.Lock()
+	return GetEnforcer(), reloadMu.Unlock
+}
+
+// Reload builds a fresh enforcer from the model and policy files and swaps
+// it in only if both load. On error the running enforcer is left untouched.
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	e, err := NewEnforcer(ModelPath(), PolicyPath())
+	if err != nil {
+		return fmt.Errorf("policy reload rejected: %w", err)
+	}
+
+	mu.Lock()
+	GlobalEnforcer = e
+	mu.Unlock()
+
+	fmt.Println("Enforcer reloaded from", ModelPath(), "and", PolicyPath())
+	return nil
+}
+
+// Watch polls the model and policy files every interval and reloads when
+// either changes. It never returns.
+func Watch(interval time.Duration) {
+	last := modTimes()
+	for range time.Tick(interval) {
+		current := modTimes()
+		if current == last {
+			continue
+		}
+		last = current
+
+		if err := Reload(); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// modTimes returns the modification times of the model and policy files
+func modTimes() [2]time.Time {
+	var times [2]time.Time
+	for i, path := range []string{ModelPath(), PolicyPath()} {
+		if info, err := os.Stat(path); err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+	return times
+}
@@ -0,0 +1,81 @@
+package enforcer
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+var validActions = map[string]bool{
+	"GET":        true,
+	"POST":       true,
+	"PUT":        true,
+	"PATCH":      true,
+	"DELETE":     true,
+	"*":          true,
+	ManageAction: true,
+}
+
+// RuleID is a stable identifier for a policy rule, derived from its full tuple.
+// Empty condition columns are left out, so adding them does not change IDs.
+func RuleID(rule []string) string {
+	sum := sha1.Sum([]byte(strings.Join(TrimRule(rule), ",")))
+	return hex.EncodeToString(sum[:6])
+}
+
+// NormalizeRule pads a rule with empty condition columns to the size of the
+// loaded model's policy definition
+func NormalizeRule(rule []string) []string {
+	return NormalizeRuleFor(GetEnforcer(), rule)
+}
+
+// NormalizeRuleFor is NormalizeRule for the policy definition of e
+func NormalizeRuleFor(e *Enforcer, rule []string) []string {
+	return PadRule(TrimRule(rule), len(e.GetModel()["p"]["p"].Tokens))
+}
+
+// conditionFields are optional policy columns
+var conditionFields = map[string]bool{
+	"cidr":  true,
+	"hours": true,
+	"days":  true,
+}
+
+// ValidateRule checks a p rule against the loaded model's policy definition
+func ValidateRule(rule []string) error {
+	tokens := GetEnforcer().GetModel()["p"]["p"].Tokens
+	if len(rule) != len(tokens) {
+		return fmt.Errorf("rule must have %d fields, got %d", len(tokens), len(rule))
+	}
+
+	for i, token := range tokens {
+		field := strings.TrimPrefix(token, "p_")
+		value := rule[i]
+
+		if strings.ContainsAny(value, ",\n") {
+			return fmt.Errorf("%s contains invalid characters", field)
+		}
+		if conditionFields[field] {
+			if err := validateCondition(field, value); err != nil {
+				return err
+			}
+			continue
+		}
+		if value == "" {
+			return fmt.Errorf("%s is required", field)
+		}
+
+		switch field {
+		case "act":
+			if !validActions[value] {
+				return fmt.Errorf("invalid action %q", value)
+			}
+		case "eft":
+			if value != "allow" && value != "deny" {
+				return fmt.Errorf("effect must be \"allow\" or \"deny\"")
+			}
+		}
+	}
+	return nil
+}
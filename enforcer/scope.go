@@ -0,0 +1,208 @@
+package enforcer
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	// GroupScopePrefix marks policy objects that grant administration of a group,
+	// e.g. "p, manager, group:staff, manage, allow"
+	GroupScopePrefix = "group:"
+	// ManageAction is the action granted on group scope objects
+	ManageAction = "manage"
+	// RootRole is the superuser role, which can never be impersonated
+	RootRole = "root"
+	// WarehouseScopePrefix marks policy objects that limit stock work to a
+	// warehouse, named by its code, e.g. "p, staff, warehouse:MAIN, *, allow".
+	// Codes rather than IDs keep the policy valid for any database. The action
+	// is the permission of the route doing the work.
+	WarehouseScopePrefix = "warehouse:"
+)
+
+// ScopeError is returned when an administrative action falls outside the actor's scope
+type ScopeError struct {
+	Reason string
+}
+
+func (e *ScopeError) Error() string {
+	return e.Reason
+}
+
+// CanManageGroup reports whether actor may assign, revoke or delete a group.
+// Scope rules are checked in the request's environment like route rules, so
+// a conditional deny on a group scope holds here too.
+func CanManageGroup(env Environment, actor, group string) (bool, error) {
+	return GetEnforcer().EnforceIn(env, actor, GroupScopePrefix+group, ManageAction)
+}
+
+// IsScopeObject reports whether a policy object is a group or warehouse scope
+// rather than a route permission
+func IsScopeObject(object string) bool {
+	return strings.HasPrefix(object, GroupScopePrefix) || strings.HasPrefix(object, WarehouseScopePrefix)
+}
+
+// CanUseWarehouse reports whether user may use permission on stock in the
+// warehouse with the given code
+func CanUseWarehouse(env Environment, user, code, permission string) (bool, error) {
+	return GetEnforcer().EnforceIn(env, user, WarehouseScopePrefix+code, permission)
+}
+
+// IsRole reports whether name is a group that users are assigned to
+func IsRole(name string) (bool, error) {
+	roles, err := GetEnforcer().GetAllRoles()
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(roles, name), nil
+}
+
+// IsPolicyName reports whether name appears in the policy as a rule subject,
+// a group or a group member. A new account with such a name would get the
+// rules written for it, e.g. a user named root would match root's rules.
+func IsPolicyName(name string) (bool, error) {
+	e := GetEnforcer()
+	subjects, err := e.GetAllSubjects()
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(subjects, name) {
+		return true, nil
+	}
+
+	rules, err := e.GetGroupingPolicy()
+	if err != nil {
+		return false, err
+	}
+	for _, rule := range rules {
+		if slices.Contains(rule, name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CanManageSubject reports whether actor may administer a policy subject.
+// A group is in scope if actor manages it; a user is in scope if actor manages
+// every group the user belongs to. Nobody administers their own account.
+func CanManageSubject(env Environment, actor, subject string) (bool, error) {
+	if actor == subject {
+		return false, nil
+	}
+
+	isRole, err := IsRole(subject)
+	if err != nil {
+		return false, err
+	}
+	if isRole {
+		return CanManageGroup(env, actor, subject)
+	}
+
+	groups, err := GetEnforcer().GetImplicitRolesForUser(subject)
+	if err != nil {
+		return false, err
+	}
+	return CanManageGroups(env, actor, groups)
+}
+
+// CanManageGroups reports whether actor may administer a user in groups, such
+// as one being restored into the groups it had when deleted. A user in no
+// group is in nobody's scope; vacuously managing all of its groups would put
+// it in everybody's.
+func CanManageGroups(env Environment, actor string, groups []string) (bool, error) {
+	if len(groups) == 0 {
+		return false, nil
+	}
+	for _, group := range groups {
+		ok, err := CanManageGroup(env, actor, group)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// CheckGrant verifies actor may grant or revoke a rule for subject: the subject must
+// be in actor's scope and actor must hold the permission itself.
+func CheckGrant(env Environment, actor, subject, object, action string) error {
+	ok, err := CanManageSubject(env, actor, subject)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &ScopeError{Reason: subject + " is outside your administration scope"}
+	}
+
+	ok, err = holdsPermission(env, actor, object, action)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &ScopeError{Reason: "You cannot grant a permission you do not hold"}
+	}
+	return nil
+}
+
+// holdsPermission reports whether actor is allowed object and action. A wildcard
+// object is only held if one of actor's own allow rules covers the whole pattern,
+// since keyMatch would otherwise treat it as a literal prefix.
+func holdsPermission(env Environment, actor, object, action string) (bool, error) {
+	e := GetEnforcer()
+	ok, err := e.EnforceIn(env, actor, object, action)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	wildcard := strings.Index(object, "*")
+	if wildcard < 0 && action != "*" {
+		return true, nil
+	}
+
+	permissions, err := e.GetImplicitPermissionsForUser(actor)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range permissions {
+		// A conditional rule only covers requests that meet its conditions
+		if len(p) < 4 || p[3] != "allow" || HasConditions(p) {
+			continue
+		}
+		if p[2] != "*" && p[2] != action {
+			continue
+		}
+		if p[1] == object {
+			return true, nil
+		}
+		if i := strings.Index(p[1], "*"); i >= 0 && wildcard >= i && object[:i] == p[1][:i] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CheckImpersonation verifies actor may act as target: target may not hold
+// the root role and must be in actor's administration scope
+func CheckImpersonation(env Environment, actor, target string) error {
+	if actor == target {
+		return &ScopeError{Reason: "You cannot impersonate yourself"}
+	}
+
+	roles, err := GetEnforcer().GetImplicitRolesForUser(target)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if role == RootRole {
+			return &ScopeError{Reason: "Root users cannot be impersonated"}
+		}
+	}
+
+	ok, err := CanManageSubject(env, actor, target)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &ScopeError{Reason: target + " is outside your administration scope"}
+	}
+	return nil
+}
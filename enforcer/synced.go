@@ -0,0 +1,108 @@
+package enforcer
+
+import (
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+)
+
+// Enforcer is a casbin.SyncedEnforcer, which serializes policy changes
+// against concurrent Enforce calls, with a decision cache in front of Enforce
+type Enforcer struct {
+	*casbin.SyncedEnforcer
+	cache *DecisionCache
+	// requestSize is the number of values in the request definition
+	requestSize int
+}
+
+// cacheWatcher clears the decision cache whenever the enforcer changes the policy
+type cacheWatcher struct {
+	cache *DecisionCache
+}
+
+func (w *cacheWatcher) SetUpdateCallback(func(string)) error { return nil }
+
+func (w *cacheWatcher) Update() error {
+	w.cache.Clear()
+	return nil
+}
+
+func (w *cacheWatcher) Close() {}
+
+// Enforce decides a request, answering repeated string requests from the cache.
+// Missing trailing request values, such as the environment, are left empty.
+func (e *Enforcer) Enforce(rvals ...interface{}) (bool, error) {
+	rvals = e.padRequest(rvals)
+	key, ok := cacheKey(rvals)
+	if !ok {
+		return e.SyncedEnforcer.Enforce(rvals...)
+	}
+
+	allowed, found, generation := e.cache.Get(key)
+	if found {
+		return allowed, nil
+	}
+
+	allowed, err := e.SyncedEnforcer.Enforce(rvals...)
+	if err != nil {
+		return false, err
+	}
+	e.cache.Put(key, allowed, generation)
+	return allowed, nil
+}
+
+// EnforceEx decides a request and returns the rule that decided it
+func (e *Enforcer) EnforceEx(rvals ...interface{}) (bool, []string, error) {
+	return e.SyncedEnforcer.EnforceEx(e.padRequest(rvals)...)
+}
+
+// padRequest extends rvals with empty strings up to the request definition
+func (e *Enforcer) padRequest(rvals []interface{}) []interface{} {
+	if len(rvals) >= e.requestSize {
+		return rvals
+	}
+
+	padded := make([]interface{}, e.requestSize)
+	copy(padded, rvals)
+	for i := len(rvals); i < len(padded); i++ {
+		padded[i] = ""
+	}
+	return padded
+}
+
+// LoadPolicy reloads the policy from the adapter and clears the cache
+func (e *Enforcer) LoadPolicy() error {
+	defer e.cache.Clear()
+	return e.SyncedEnforcer.LoadPolicy()
+}
+
+// ClearPolicy removes every rule from memory and clears the cache
+func (e *Enforcer) ClearPolicy() {
+	defer e.cache.Clear()
+	e.SyncedEnforcer.ClearPolicy()
+}
+
+// GetImplicitUsersForRole is not synchronized by casbin.SyncedEnforcer
+func (e *Enforcer) GetImplicitUsersForRole(name string, domain ...string) ([]string, error) {
+	e.GetLock().RLock()
+	defer e.GetLock().RUnlock()
+	return e.SyncedEnforcer.GetImplicitUsersForRole(name, domain...)
+}
+
+// CacheLen returns the number of cached decisions
+func (e *Enforcer) CacheLen() int {
+	return e.cache.Len()
+}
+
+// cacheKey joins a request made only of strings into a cache key
+func cacheKey(rvals []interface{}) (string, bool) {
+	parts := make([]string, len(rvals))
+	for i, rval := range rvals {
+		s, ok := rval.(string)
+		if !ok {
+			return "", false
+		}
+		parts[i] = s
+	}
+	return strings.Join(parts, "\x00"), true
+}
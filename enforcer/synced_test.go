@@ -0,0 +1,271 @@
+package enforcer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPolicy copies the shipped model and policy into a temporary directory
+// and points CASBIN_MODEL_PATH and CASBIN_POLICY_PATH at the copies, so tests
+// can never write to config/
+func testPolicy(t testing.TB) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for env, name := range map[string]string{"CASBIN_MODEL_PATH": "pbac_model.conf", "CASBIN_POLICY_PATH": "policy.csv"} {
+		data, err := os.ReadFile(filepath.Join("..", "config", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(env, path)
+	}
+}
+
+func newTestEnforcer(t testing.TB) *Enforcer {
+	t.Helper()
+
+	testPolicy(t)
+	e, err := NewEnforcer(ModelPath(), PolicyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.EnableAutoSave(false)
+	return e
+}
+
+// readers keeps n goroutines enforcing a spread of requests until stop is closed
+func readers(t testing.TB, n int, enforce func() *Enforcer, stop <-chan struct{}, wg *sync.WaitGroup) {
+	subjects := []string{"toanpham", "toanleader", "toanmanager", "rootuser", "nobody"}
+	objects := []string{"products:read", "products:delete", "users:list", "reports:products", "stock:transfer"}
+	actions := []string{"GET", "POST", "DELETE"}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(seed int) {
+			defer wg.Done()
+			for n := seed; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				sub := subjects[n%len(subjects)]
+				obj := objects[(n/len(subjects))%len(objects)]
+				if _, err := enforce().Enforce(sub, obj, actions[n%len(actions)]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i * 7919)
+	}
+}
+
+// TestEnforceDuringPolicyChanges checks that a policy change is seen by the
+// very next decision while other goroutines keep the cache busy. Run it with
+// -race to also check for data races.
+func TestEnforceDuringPolicyChanges(t *testing.T) {
+	e := newTestEnforcer(t)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	readers(t, 8, func() *Enforcer { return e }, stop, &wg)
+
+	for i := 0; i < 500; i++ {
+		granted := i%2 == 0
+		var err error
+		if granted {
+			_, err = e.AddGroupingPolicy("test-user", "manager")
+		} else {
+			_, err = e.RemoveGroupingPolicy("test-user", "manager")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		allowed, err := e.Enforce("test-user", "users:list", "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != granted {
+			t.Fatalf("change %d: stale decision, allowed=%v after setting membership to %v", i, allowed, granted)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+// TestReloadDuringEnforce swaps the global enforcer while requests use it
+func TestReloadDuringEnforce(t *testing.T) {
+	testPolicy(t)
+	if err := InitializeEnforcer(); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	readers(t, 8, GetEnforcer, stop, &wg)
+
+	var reloads atomic.Int32
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := Reload(); err != nil {
+					t.Error(err)
+					return
+				}
+				reloads.Add(1)
+			}
+		}()
+	}
+
+	// Policy mutation on whichever enforcer is live at the time
+	for i := 0; i < 100; i++ {
+		if _, err := GetEnforcer().AddGroupingPolicy(fmt.Sprintf("test-user-%d", i), "staff"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for reloads.Load() < 40 {
+		time.Sleep(time.Millisecond)
+		if t.Failed() {
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	allowed, err := GetEnforcer().Enforce("toanpham", "products:read", "GET")
+	if err != nil || !allowed {
+		t.Fatalf("after reloads toanpham products:read GET = %v, %v; want allowed", allowed, err)
+	}
+}
+
+func TestSaveDuringReload(t *testing.T) {
+	testPolicy(t)
+	if err := InitializeEnforcer(); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := Reload(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Every saved change must survive the reloads around it
+	const writes = 200
+	var writers sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := w; i < writes; i += 4 {
+				e, unlock := LockForUpdate()
+				_, err := e.AddGroupingPolicy(fmt.Sprintf("test-user-%d", i), "staff")
+				if err == nil {
+					err = e.SavePolicy()
+				}
+				unlock()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	writers.Wait()
+	close(stop)
+	wg.Wait()
+
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < writes; i++ {
+		user := fmt.Sprintf("test-user-%d", i)
+		if ok, _ := GetEnforcer().HasGroupingPolicy(user, "staff"); !ok {
+			t.Errorf("%s lost from staff", user)
+		}
+	}
+}
+
+func BenchmarkEnforceCached(b *testing.B) {
+	e := newTestEnforcer(b)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			e.Enforce("toanpham", "products:read", "GET")
+		}
+	})
+}
+
+func BenchmarkEnforceUncached(b *testing.B) {
+	b.Setenv("DECISION_CACHE_SIZE", "0")
+	e := newTestEnforcer(b)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			e.Enforce("toanpham", "products:read", "GET")
+		}
+	})
+}
+
+// BenchmarkEnforceDuringPolicyChanges measures decisions while a goroutine
+// keeps changing the policy, which clears the cache on every change
+func BenchmarkEnforceDuringPolicyChanges(b *testing.B) {
+	e := newTestEnforcer(b)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for granted := false; ; granted = !granted {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if granted {
+				e.RemoveGroupingPolicy("bench-user", "leader")
+			} else {
+				e.AddGroupingPolicy("bench-user", "leader")
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			e.Enforce("toanpham", "products:read", "GET")
+		}
+	})
+	b.StopTimer()
+
+	close(stop)
+	<-done
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"casbin-demo/enforcer"
+	"casbin-demo/policy"
+	"casbin-demo/routes"
+)
+
+// GetPolicyCoverage reports protected routes nobody can reach, routes only
+// wildcard rules grant, and rules that match no route
+func GetPolicyCoverage(w http.ResponseWriter, r *http.Request) {
+	report, err := policy.Coverage(routes.GetRegistry())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetAccessMatrix returns which roles and users can call which endpoints, as
+// csv, json or markdown. With diff=true it lists the changes from the baseline.
+func GetAccessMatrix(w http.ResponseWriter, r *http.Request) {
+	matrix, err := policy.AccessMatrix(routes.GetRegistry())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("diff") == "true" {
+		path := os.Getenv("ACCESS_MATRIX_BASELINE")
+		if path == "" {
+			path = "./config/access_matrix.csv"
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			http.Error(w, "Access matrix baseline not found", http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+
+		baseline, err := policy.ReadMatrixCSV(file)
+		if err != nil {
+			http.Error(w, "Invalid access matrix baseline: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		changes := policy.Diff(baseline, matrix)
+		if changes == nil {
+			changes = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"changes": changes})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+	case "markdown", "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	case "csv", "":
+		w.Header().Set("Content-Type", "text/csv")
+	default:
+		http.Error(w, "format must be csv, json or markdown", http.StatusBadRequest)
+		return
+	}
+
+	matrix.Write(w, format)
+}
+
+// ReloadPolicy reloads the model and policy files. An invalid model or policy
+// is rejected and the running policy stays in effect.
+func ReloadPolicy(w http.ResponseWriter, r *http.Request) {
+	if err := enforcer.Reload(); err != nil {
+		fmt.Println(err)
+		http.Error(w, "Policy reload failed: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Policy reloaded successfully"})
+}
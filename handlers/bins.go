@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"casbin-demo/database"
+	"casbin-demo/middlewares"
+	"casbin-demo/models"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// putAwaySuggestions is how many bins a stock-in without a bin suggests
+	putAwaySuggestions = 3
+	maxPickLines       = 500
+)
+
+// binLabelPattern matches a zone, aisle, shelf or bin label. Dashes are left
+// out because they separate the labels in a bin code.
+var binLabelPattern = regexp.MustCompile(`^[A-Z0-9]{1,10}$`)
+
+// warehouseFromPath reads the warehouseId route variable and checks the user
+// may exercise the route's permission there, answering the request itself
+// when either fails
+func warehouseFromPath(w http.ResponseWriter, r *http.Request) (models.Warehouse, bool) {
+	warehouseID, err := strconv.Atoi(mux.Vars(r)["warehouseId"])
+	if err != nil || warehouseID <= 0 {
+		http.Error(w, "Invalid warehouse ID", http.StatusBadRequest)
+		return models.Warehouse{}, false
+	}
+
+	warehouse, err := database.GetWarehouse(warehouseID)
+	if err == database.ErrWarehouseNotFound {
+		http.Error(w, "Warehouse not found", http.StatusNotFound)
+		return warehouse, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return warehouse, false
+	}
+	return warehouse, authorizeWarehouse(w, r, warehouse)
+}
+
+// resolveBin checks that a stock request's bin exists and is in its
+// warehouse, defaulting the warehouse to the bin's. It answers the request
+// itself when the bin does not fit.
+func resolveBin(w http.ResponseWriter, binID int, warehouseID *int) bool {
+	if binID == 0 {
+		return true
+	}
+
+	bin, err := database.GetBin(binID)
+	if err == database.ErrBinNotFound {
+		http.Error(w, fmt.Sprintf("Bin %d not found", binID), http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if *warehouseID == 0 {
+		*warehouseID = bin.WarehouseID
+	}
+	if *warehouseID != bin.WarehouseID {
+		http.Error(w, fmt.Sprintf("Bin %s is not in warehouse %d", bin.Code, *warehouseID), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// ListBins returns a warehouse's bins in walking path order
+func ListBins(w http.ResponseWriter, r *http.Request) {
+	warehouse, ok := warehouseFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	bins, err := database.ListBins(warehouse.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bins)
+}
+
+// CreateBin adds a bin location to a warehouse
+func CreateBin(w http.ResponseWriter, r *http.Request) {
+	warehouse, ok := warehouseFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.BinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	labels := []*string{&req.Zone, &req.Aisle, &req.Shelf, &req.Bin}
+	for _, label := range labels {
+		*label = strings.ToUpper(strings.TrimSpace(*label))
+		if !binLabelPattern.MatchString(*label) {
+			http.Error(w, "Zone, aisle, shelf and bin must each be 1 to 10 letters or digits", http.StatusBadRequest)
+			return
+		}
+	}
+
+	bin, err := database.CreateBin(warehouse.ID, req)
+	if err == database.ErrDuplicateBin {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(bin)
+}
+
+// MoveBinStock moves stock of a product between bins of one warehouse. A bin
+// ID of 0 is the warehouse's unassigned stock.
+func MoveBinStock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["productId"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.BinMoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Quantity <= 0 {
+		http.Error(w, "Quantity must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.FromBinID == req.ToBinID {
+		http.Error(w, "from_bin_id and to_bin_id must be different bins", http.StatusBadRequest)
+		return
+	}
+
+	// Both bins must be in the same warehouse; moving between warehouses is a transfer
+	var warehouseID int
+	if !resolveBin(w, req.FromBinID, &warehouseID) || !resolveBin(w, req.ToBinID, &warehouseID) {
+		return
+	}
+	warehouse, ok := useWarehouse(w, r, warehouseID)
+	if !ok {
+		return
+	}
+
+	stock := models.StockRequest{Quantity: req.Quantity, Unit: req.Unit}
+	if !convertStockUnit(w, productID, &stock) {
+		return
+	}
+	req.Quantity = stock.Quantity
+
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return
+	}
+
+	op := models.Operation{
+		ProductID: productID,
+		UserID:    claims.UserID,
+		ActorID:   claims.ActorID,
+	}
+
+	move, err := database.MoveBinStock(op, warehouse.ID, req)
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(move)
+}
+
+// CreatePickList works out which bins to pick a set of outgoing quantities
+// from, in walking path order. It reserves nothing; stock is taken off the
+// shelves when it is removed from the bins listed.
+func CreatePickList(w http.ResponseWriter, r *http.Request) {
+	warehouse, ok := warehouseFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.PickListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Lines) == 0 || len(req.Lines) > maxPickLines {
+		http.Error(w, fmt.Sprintf("A pick list needs 1 to %d lines", maxPickLines), http.StatusBadRequest)
+		return
+	}
+
+	// Lines for the same product are picked together
+	var productIDs []int
+	requested := map[int]int{}
+	for _, line := range req.Lines {
+		if line.ProductID <= 0 || line.Quantity <= 0 {
+			http.Error(w, "Every line needs a product_id and a positive quantity", http.StatusBadRequest)
+			return
+		}
+		stock := models.StockRequest{Quantity: line.Quantity, Unit: line.Unit}
+		if !convertStockUnit(w, line.ProductID, &stock) {
+			return
+		}
+		if _, ok := requested[line.ProductID]; !ok {
+			productIDs = append(productIDs, line.ProductID)
+		}
+		requested[line.ProductID] += stock.Quantity
+	}
+
+	stock, err := database.GetPickableStock(warehouse.ID, productIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pickList := allocatePicks(stock, productIDs, requested)
+	pickList.WarehouseID = warehouse.ID
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pickList)
+}
+
+// allocatePicks takes the requested quantities from the shelved stock, which
+// is in walking path order, bin by bin until each product is covered. Picks
+// in the same bin share a stop.
+func allocatePicks(stock []models.BinStock, productIDs []int, requested map[int]int) models.PickList {
+	pickList := models.PickList{Picks: []models.Pick{}, Shortages: []models.PickShortage{}}
+
+	remaining := make(map[int]int, len(requested))
+	for productID, quantity := range requested {
+		remaining[productID] = quantity
+	}
+
+	stop, lastBin := 0, 0
+	for _, line := range stock {
+		take := min(remaining[line.ProductID], line.Quantity)
+		if take <= 0 {
+			continue
+		}
+		if line.Bin.ID != lastBin {
+			stop++
+			lastBin = line.Bin.ID
+		}
+		pickList.Picks = append(pickList.Picks, models.Pick{
+			Stop:      stop,
+			BinID:     line.Bin.ID,
+			BinCode:   line.Bin.Code,
+			ProductID: line.ProductID,
+			SKU:       line.SKU,
+			Name:      line.Name,
+			Quantity:  take,
+		})
+		remaining[line.ProductID] -= take
+	}
+
+	for _, productID := range productIDs {
+		if remaining[productID] > 0 {
+			pickList.Shortages = append(pickList.Shortages, models.PickShortage{
+				ProductID: productID,
+				Requested: requested[productID],
+				Allocated: requested[productID] - remaining[productID],
+			})
+		}
+	}
+	return pickList
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+
+	"casbin-demo/models"
+)
+
+const (
+	maxAttributes     = 50
+	maxAttributesSize = 16 << 10
+	// maxUnitFactor is the largest factor product_units.factor can hold
+	maxUnitFactor = 1e8
+)
+
+var (
+	skuPattern          = regexp.MustCompile(`^[A-Z0-9][A-Z0-9._-]{0,63}$`)
+	attributeKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// normalizeSKU upper-cases a SKU and checks that it is 1 to 64 letters,
+// digits, dots, dashes or underscores
+func normalizeSKU(sku string) (string, error) {
+	sku = strings.ToUpper(strings.TrimSpace(sku))
+	if !skuPattern.MatchString(sku) {
+		return sku, fmt.Errorf("SKU must be 1 to 64 letters, digits, '.', '-' or '_', starting with a letter or digit")
+	}
+	return sku, nil
+}
+
+// validateBarcode checks that a barcode is an EAN-8, UPC-A or EAN-13 code
+// with a correct check digit
+func validateBarcode(barcode string) error {
+	switch len(barcode) {
+	case 8, 12, 13:
+	default:
+		return fmt.Errorf("barcode %q must have 8 (EAN-8), 12 (UPC-A) or 13 (EAN-13) digits", barcode)
+	}
+
+	// GS1 check digit: weights alternate 3 and 1 from the right, check digit excluded
+	sum := 0
+	for i := len(barcode) - 2; i >= 0; i-- {
+		digit := barcode[i]
+		if digit < '0' || digit > '9' {
+			return fmt.Errorf("barcode %q must contain only digits", barcode)
+		}
+		weight := 1
+		if (len(barcode)-2-i)%2 == 0 {
+			weight = 3
+		}
+		sum += int(digit-'0') * weight
+	}
+	check := barcode[len(barcode)-1]
+	if check < '0' || check > '9' || int(check-'0') != (10-sum%10)%10 {
+		return fmt.Errorf("barcode %q has an invalid check digit", barcode)
+	}
+	return nil
+}
+
+// normalizeBarcodes trims and validates barcodes and rejects duplicates
+func normalizeBarcodes(barcodes []string) ([]string, error) {
+	normalized := make([]string, 0, len(barcodes))
+	for _, barcode := range barcodes {
+		barcode = strings.TrimSpace(barcode)
+		if err := validateBarcode(barcode); err != nil {
+			return nil, err
+		}
+		if slices.Contains(normalized, barcode) {
+			return nil, fmt.Errorf("barcode %q is listed twice", barcode)
+		}
+		normalized = append(normalized, barcode)
+	}
+	return normalized, nil
+}
+
+// validateUnits checks the base unit and that every purchase unit is another
+// known unit holding a positive number of base units
+func validateUnits(baseUnit string, purchaseUnits map[string]float64) error {
+	if !slices.Contains(models.UnitsOfMeasure, baseUnit) {
+		return fmt.Errorf("base unit must be one of %s", strings.Join(models.UnitsOfMeasure, ", "))
+	}
+	for unit, factor := range purchaseUnits {
+		if !slices.Contains(models.UnitsOfMeasure, unit) {
+			return fmt.Errorf("purchase unit %q must be one of %s", unit, strings.Join(models.UnitsOfMeasure, ", "))
+		}
+		if unit == baseUnit {
+			return fmt.Errorf("purchase unit %q is the base unit", unit)
+		}
+		if factor <= 0 || factor >= maxUnitFactor {
+			return fmt.Errorf("purchase unit %q must hold a positive number of base units below %g", unit, float64(maxUnitFactor))
+		}
+	}
+	return nil
+}
+
+// validateAttributes limits the number, names and size of custom attributes
+func validateAttributes(attributes map[string]interface{}) error {
+	if len(attributes) > maxAttributes {
+		return fmt.Errorf("a product can have at most %d attributes", maxAttributes)
+	}
+	for key := range attributes {
+		if !attributeKeyPattern.MatchString(key) {
+			return fmt.Errorf("attribute name %q must be 1 to 64 letters, digits, '.', '-' or '_'", key)
+		}
+	}
+	data, err := json.Marshal(attributes)
+	if err != nil || len(data) > maxAttributesSize {
+		return fmt.Errorf("attributes must encode to at most %d bytes of JSON", maxAttributesSize)
+	}
+	return nil
+}
+
+// validateProductRequest checks a new product and normalizes its SKU,
+// barcodes and base unit
+func validateProductRequest(req *models.ProductRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return fmt.Errorf("product name is required")
+	}
+	if req.UnitPrice <= 0 {
+		return fmt.Errorf("unit price must be greater than 0")
+	}
+	if req.Quantity < 0 {
+		return fmt.Errorf("quantity cannot be negative")
+	}
+
+	var err error
+	if req.SKU, err = normalizeSKU(req.SKU); err != nil {
+		return err
+	}
+	if req.Barcodes, err = normalizeBarcodes(req.Barcodes); err != nil {
+		return err
+	}
+	if req.BaseUnit == "" {
+		req.BaseUnit = models.DefaultBaseUnit
+	}
+	if err := validateUnits(req.BaseUnit, req.PurchaseUnits); err != nil {
+		return err
+	}
+	return validateAttributes(req.Attributes)
+}
+
+// validateProductUpdate checks the fields an update sets, against the
+// current product for fields that depend on each other
+func validateProductUpdate(update *models.ProductUpdate, current models.Product) error {
+	if update.Name != nil && strings.TrimSpace(*update.Name) == "" {
+		return fmt.Errorf("product name cannot be empty")
+	}
+	if update.UnitPrice != nil && *update.UnitPrice <= 0 {
+		return fmt.Errorf("unit price must be greater than 0")
+	}
+	if update.Quantity != nil && *update.Quantity < 0 {
+		return fmt.Errorf("quantity cannot be negative")
+	}
+	if update.CategoryID != nil && *update.CategoryID < 0 {
+		return fmt.Errorf("invalid category ID")
+	}
+
+	if update.SKU != nil {
+		sku, err := normalizeSKU(*update.SKU)
+		if err != nil {
+			return err
+		}
+		update.SKU = &sku
+	}
+	if update.Barcodes != nil {
+		barcodes, err := normalizeBarcodes(*update.Barcodes)
+		if err != nil {
+			return err
+		}
+		update.Barcodes = &barcodes
+	}
+
+	if update.BaseUnit != nil || update.PurchaseUnits != nil {
+		baseUnit, purchaseUnits := current.BaseUnit, current.PurchaseUnits
+		if update.BaseUnit != nil {
+			baseUnit = *update.BaseUnit
+		}
+		if update.PurchaseUnits != nil {
+			purchaseUnits = *update.PurchaseUnits
+		}
+		if err := validateUnits(baseUnit, purchaseUnits); err != nil {
+			return err
+		}
+	}
+
+	if update.Attributes != nil {
+		return validateAttributes(*update.Attributes)
+	}
+	return nil
+}
+
+// baseQuantity converts a quantity of a unit holding factor base units into
+// base units, reporting false when the result is not a whole number
+func baseQuantity(quantity int, factor float64) (int, bool) {
+	base := float64(quantity) * factor
+	if math.Abs(base-math.Round(base)) > 1e-6 || base > math.MaxInt32 {
+		return 0, false
+	}
+	return int(math.Round(base)), true
+}
+
+func toProductResponse(p models.Product) models.ProductResponse {
+	return models.ProductResponse{
+		ID:            p.ID,
+		Name:          p.Name,
+		Quantity:      p.Quantity,
+		UnitPrice:     p.UnitPrice,
+		Version:       p.Version,
+		SKU:           p.SKU,
+		Barcodes:      p.Barcodes,
+		CategoryID:    p.CategoryID,
+		BaseUnit:      p.BaseUnit,
+		PurchaseUnits: p.PurchaseUnits,
+		Attributes:    p.Attributes,
+		DeletedAt:     p.DeletedAt,
+	}
+}
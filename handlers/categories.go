@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"casbin-demo/database"
+	"casbin-demo/models"
+)
+
+// ListCategories returns the product category tree
+func ListCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := database.ListCategories()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(categories)
+}
+
+// CreateCategory adds a category at the root or under parent_id
+func CreateCategory(w http.ResponseWriter, r *http.Request) {
+	var req models.CategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 || strings.Contains(req.Name, " / ") {
+		http.Error(w, "Category name must be 1 to 100 characters and cannot contain ' / '", http.StatusBadRequest)
+		return
+	}
+
+	category, err := database.CreateCategory(req)
+	if err != nil {
+		switch err {
+		case database.ErrCategoryNotFound:
+			http.Error(w, "Parent category not found", http.StatusBadRequest)
+		case database.ErrDuplicateCategory:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(category)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"casbin-demo/database"
+	"casbin-demo/middlewares"
+	"casbin-demo/models"
+
+	"github.com/gorilla/mux"
+)
+
+// GetProductChanges lists a product's adjustments with the before and after
+// value of every field they changed, newest first
+func GetProductChanges(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["productId"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	changes, err := database.GetProductChanges(productID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+// RevertProductChange undoes an adjustment by applying its inverse as a new
+// adjustment. Like other product updates it requires If-Match.
+func RevertProductChange(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["productId"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	operationID, err := strconv.Atoi(vars["operationId"])
+	if err != nil {
+		http.Error(w, "Invalid operation ID", http.StatusBadRequest)
+		return
+	}
+
+	versions, ok := expectedVersions(w, r)
+	if !ok {
+		return
+	}
+
+	// The reason is optional, so an empty body is fine
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return
+	}
+
+	op := models.Operation{
+		ProductID: productID,
+		Reason:    req.Reason,
+		UserID:    claims.UserID,
+		ActorID:   claims.ActorID,
+		Versions:  versions,
+	}
+
+	version, err := database.RevertProductChange(op, operationID)
+	if err != nil {
+		switch err {
+		case database.ErrChangeNotFound:
+			http.Error(w, "Change not found", http.StatusNotFound)
+		case database.ErrChangeConflict:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			writeProductError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", productETag(version))
+	json.NewEncoder(w).Encode(map[string]string{"message": "Change reverted successfully"})
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// productETag is the strong ETag of a product: its version
+func productETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// bodyETag is the strong ETag of an encoded response, such as a page of a listing
+func bodyETag(data []byte) string {
+	hash := sha256.Sum256(data)
+	return strconv.Quote(hex.EncodeToString(hash[:16]))
+}
+
+// splitETags splits an If-Match or If-None-Match header into its entity tags
+func splitETags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// notModified answers a GET with 304 when If-None-Match names the current
+// ETag. Weak and strong tags compare equal here, as RFC 9110 requires.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	for _, tag := range splitETags(r.Header.Get("If-None-Match")) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// expectedVersions reads the product versions a write is conditional on from
+// If-Match. A missing header is answered with 428 and an unusable one with
+// 400; "*" matches any version and yields no versions.
+func expectedVersions(w http.ResponseWriter, r *http.Request) ([]int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		http.Error(w, "If-Match header with the product's ETag is required", http.StatusPreconditionRequired)
+		return nil, false
+	}
+
+	var versions []int
+	for _, tag := range splitETags(header) {
+		if tag == "*" {
+			return nil, true
+		}
+
+		// If-Match uses strong comparison, so weak tags never match
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		value, err := strconv.Unquote(tag)
+		if err != nil {
+			http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+			return nil, false
+		}
+		// A tag that is not a version cannot match the product
+		if version, err := strconv.Atoi(value); err == nil {
+			versions = append(versions, version)
+		}
+	}
+
+	if len(versions) == 0 {
+		http.Error(w, "Product was modified; fetch it again", http.StatusPreconditionFailed)
+		return nil, false
+	}
+	return versions, true
+}
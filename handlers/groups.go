@@ -6,16 +6,59 @@ import (
 	"net/http"
 
 	"casbin-demo/enforcer"
+	"casbin-demo/middlewares"
+	"casbin-demo/models"
 
 	"github.com/gorilla/mux"
 )
 
+// requireGroupScope rejects the request unless the caller may administer the group
+func requireGroupScope(w http.ResponseWriter, r *http.Request, group string) bool {
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return false
+	}
+
+	allowed, err := enforcer.CanManageGroup(middlewares.RequestEnvironment(r), claims.Username, group)
+	if err != nil {
+		http.Error(w, "Authorization error", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		http.Error(w, "Group "+group+" is outside your administration scope", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireUserMember rejects group memberships whose member is itself a group;
+// nesting groups would hand a group's permissions to members outside the
+// caller's scope
+func requireUserMember(w http.ResponseWriter, member string) bool {
+	isRole, err := enforcer.IsRole(member)
+	if err != nil {
+		http.Error(w, "Authorization error", http.StatusInternalServerError)
+		return false
+	}
+	if isRole {
+		http.Error(w, member+" is a group, not a user", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
 func AddUserToGroup(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	username := vars["username"]
 	group := vars["groupname"]
 
-	enforcer := enforcer.GetEnforcer()
+	if !requireGroupScope(w, r, group) || !requireUserMember(w, username) {
+		return
+	}
+
+	enforcer, unlock := enforcer.LockForUpdate()
+	defer unlock()
 	fmt.Println("Adding user", username, "to group", group)
 	_, err := enforcer.AddGroupingPolicy(username, group)
 	if err != nil {
@@ -38,7 +81,12 @@ func RemoveUserFromGroup(w http.ResponseWriter, r *http.Request) {
 	username := vars["username"]
 	groupname := vars["groupname"]
 
-	e := enforcer.GetEnforcer()
+	if !requireGroupScope(w, r, groupname) || !requireUserMember(w, username) {
+		return
+	}
+
+	e, unlock := enforcer.LockForUpdate()
+	defer unlock()
 
 	fmt.Println("Removing user", username, "from group", groupname)
 	// Remove user from group using Casbin's API
@@ -95,7 +143,12 @@ func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	groupname := vars["groupname"]
 
-	e := enforcer.GetEnforcer()
+	if !requireGroupScope(w, r, groupname) {
+		return
+	}
+
+	e, unlock := enforcer.LockForUpdate()
+	defer unlock()
 
 	// Delete the role (group)
 	removed, err := e.DeleteRole(groupname)
@@ -127,7 +180,24 @@ func DeletePermissions(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
 
-	e := enforcer.GetEnforcer()
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return
+	}
+
+	allowed, err := enforcer.CanManageSubject(middlewares.RequestEnvironment(r), claims.Username, name)
+	if err != nil {
+		http.Error(w, "Authorization error", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, name+" is outside your administration scope", http.StatusForbidden)
+		return
+	}
+
+	e, unlock := enforcer.LockForUpdate()
+	defer unlock()
 
 	// Remove all permissions for the user/group
 	removed, err := e.DeletePermissionsForUser(name)
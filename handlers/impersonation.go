@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"casbin-demo/database"
+	"casbin-demo/enforcer"
+	"casbin-demo/middlewares"
+	"casbin-demo/models"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultImpersonationMinutes = 15
+	maxImpersonationMinutes     = 60
+)
+
+// ImpersonateUser issues a short-lived token that is authorized as the target
+// user while recording the caller as the real actor
+func ImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars["username"]
+
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return
+	}
+	if claims.Impersonated() {
+		http.Error(w, "Nested impersonation is not allowed", http.StatusForbidden)
+		return
+	}
+
+	var req models.ImpersonationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInMinutes < 0 || req.ExpiresInMinutes > maxImpersonationMinutes {
+		http.Error(w, fmt.Sprintf("Expiry must be between 1 and %d minutes", maxImpersonationMinutes), http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInMinutes == 0 {
+		req.ExpiresInMinutes = defaultImpersonationMinutes
+	}
+
+	if err := enforcer.CheckImpersonation(middlewares.RequestEnvironment(r), claims.Username, username); err != nil {
+		if _, ok := err.(*enforcer.ScopeError); ok {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Authorization error", http.StatusInternalServerError)
+		return
+	}
+
+	target, err := database.GetUserByUsername(username)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if target.Status == models.UserStatusSuspended {
+		http.Error(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	imp, err := database.CreateImpersonation(models.Impersonation{
+		ActorID:   claims.UserID,
+		TargetID:  target.ID,
+		Reason:    req.Reason,
+		ExpiresAt: time.Now().Add(time.Duration(req.ExpiresInMinutes) * time.Minute),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token, err := generateImpersonationToken(target, claims, imp)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Println("actor", claims.Username, "impersonating", target.Username, "until", imp.ExpiresAt.Format(time.RFC3339), ", reason", req.Reason)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.ImpersonationResponse{
+		Token:     token,
+		Username:  target.Username,
+		Actor:     claims.Username,
+		ExpiresAt: imp.ExpiresAt,
+	})
+}
+
+// ListImpersonations returns the impersonation audit trail
+func ListImpersonations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(query.Get("page_size"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	impersonations, err := database.ListImpersonations(query.Get("actor"), query.Get("target"), (page-1)*pageSize, pageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(impersonations)
+}
+
+func generateImpersonationToken(target models.User, actor *models.Claims, imp models.Impersonation) (string, error) {
+	claims := &models.Claims{
+		Username:      target.Username,
+		UserID:        target.ID,
+		ActorUsername: actor.Username,
+		ActorID:       actor.UserID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        strconv.Itoa(imp.ID),
+			IssuedAt:  jwt.NewNumericDate(imp.CreatedAt),
+			ExpiresAt: jwt.NewNumericDate(imp.ExpiresAt),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"casbin-demo/database"
+	"casbin-demo/models"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultHistoryDays = 30
+	maxHistoryPoints   = 1000
+)
+
+// parseReportTime accepts an RFC 3339 time or a date. A date means the end
+// of that day, so as_of=2025-06-30 includes everything done on 30 June.
+func parseReportTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date (2006-01-02) or an RFC 3339 time", value)
+	}
+	if endOfDay {
+		return day.AddDate(0, 0, 1).Add(-time.Microsecond), nil
+	}
+	return day, nil
+}
+
+// GetInventoryReport reconstructs every product's quantity, price and value
+// at the as_of time, which defaults to now
+func GetInventoryReport(w http.ResponseWriter, r *http.Request) {
+	asOf := time.Now()
+	if value := r.URL.Query().Get("as_of"); value != "" {
+		var err error
+		if asOf, err = parseReportTime(value, true); err != nil {
+			http.Error(w, "Invalid as_of: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	items, err := database.GetInventoryAsOf(asOf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report := models.InventoryReport{AsOf: asOf, Items: items}
+	for _, item := range items {
+		report.TotalValue += item.Value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetProductHistory returns a product's stock level after every ledger entry
+// between from and to, or at the end of each hour, day, week or month
+func GetProductHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["productId"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now()
+	if value := query.Get("to"); value != "" {
+		if to, err = parseReportTime(value, true); err != nil {
+			http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	from := to.AddDate(0, 0, -defaultHistoryDays)
+	if value := query.Get("from"); value != "" {
+		if from, err = parseReportTime(value, false); err != nil {
+			http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	interval := query.Get("interval")
+	switch interval {
+	case "", "hour", "day", "week", "month":
+	default:
+		http.Error(w, "interval must be hour, day, week or month", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := database.GetProductByID(productID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	opening, levels, err := database.GetStockHistory(productID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if interval != "" {
+		if levels, err = bucketStockLevels(opening, levels, from, to, interval); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.StockHistoryResponse{
+		ProductID: productID,
+		From:      from,
+		To:        to,
+		Interval:  interval,
+		Opening:   opening,
+		Levels:    levels,
+	})
+}
+
+// bucketStockLevels turns ledger levels into one point per interval, at the
+// end of each interval, carrying the last known quantity through quiet periods
+func bucketStockLevels(opening int, levels []models.StockLevel, from, to time.Time, interval string) ([]models.StockLevel, error) {
+	next := func(t time.Time) time.Time {
+		switch interval {
+		case "hour":
+			return t.Add(time.Hour)
+		case "day":
+			return t.AddDate(0, 0, 1)
+		case "week":
+			return t.AddDate(0, 0, 7)
+		default:
+			return t.AddDate(0, 1, 0)
+		}
+	}
+
+	var buckets []models.StockLevel
+	balance, i := opening, 0
+	for end := next(from); ; end = next(end) {
+		if end.After(to) {
+			end = to
+		}
+		for i < len(levels) && !levels[i].At.After(end) {
+			balance = levels[i].Quantity
+			i++
+		}
+
+		buckets = append(buckets, models.StockLevel{At: end, Quantity: balance})
+		if len(buckets) > maxHistoryPoints {
+			return nil, fmt.Errorf("too many points; use a longer interval or a shorter range")
+		}
+		if !end.Before(to) {
+			return buckets, nil
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"casbin-demo/database"
+	"casbin-demo/enforcer"
+	"casbin-demo/middlewares"
+	"casbin-demo/models"
+	"casbin-demo/oidc"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultInvitationHours = 72
+
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateInvitation issues an invitation that places the invitee in the given groups
+func CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	var req models.InvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return
+	}
+
+	if len(req.Groups) == 0 {
+		http.Error(w, "At least one group is required", http.StatusBadRequest)
+		return
+	}
+	for _, group := range req.Groups {
+		allowed, err := enforcer.CanManageGroup(middlewares.RequestEnvironment(r), claims.Username, group)
+		if err != nil {
+			http.Error(w, "Authorization error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Group "+group+" is outside your administration scope", http.StatusForbidden)
+			return
+		}
+	}
+	if req.ExpiresInHours < 0 || req.ExpiresInHours > 24*30 {
+		http.Error(w, "Expiry must be between 1 hour and 30 days", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInHours == 0 {
+		req.ExpiresInHours = defaultInvitationHours
+	}
+
+	token, err := oidc.RandomString(32)
+	if err != nil {
+		http.Error(w, "Error generating invitation", http.StatusInternalServerError)
+		return
+	}
+
+	invitation, err := database.CreateInvitation(models.Invitation{
+		Email:     req.Email,
+		Groups:    req.Groups,
+		ExpiresAt: time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour),
+		CreatedBy: claims.UserID,
+	}, hashInvitationToken(token))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The token is only ever returned here; the database keeps its hash
+	invitation.Token = token
+
+	fmt.Println("Invitation", invitation.ID, "created by", claims.Username, "for groups", req.Groups)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// RedeemInvitation lets an invitee set their credentials and joins them to the invited groups
+func RedeemInvitation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	token := vars["token"]
+
+	var req models.RedeemInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateCredentials(req.Username, req.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !requireFreeUsername(w, req.Username) {
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error encrypting password", http.StatusInternalServerError)
+		return
+	}
+
+	e, unlock := enforcer.LockForUpdate()
+	defer unlock()
+	var assigned [][]string
+	user, err := database.RedeemInvitation(hashInvitationToken(token), req.Username, string(hashedPassword), func(groups []string) error {
+		rules := make([][]string, 0, len(groups))
+		for _, group := range groups {
+			rules = append(rules, []string{req.Username, group})
+		}
+
+		if _, err := e.AddGroupingPolicies(rules); err != nil {
+			return fmt.Errorf("failed to assign groups: %v", err)
+		}
+		assigned = rules
+
+		if err := e.SavePolicy(); err != nil {
+			return fmt.Errorf("failed to save policy: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		// Undo the group assignment if the user was not created after all
+		if assigned != nil {
+			e.RemoveGroupingPolicies(assigned)
+			e.SavePolicy()
+		}
+
+		if err == database.ErrInvitationUnavailable {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		fmt.Println("Error redeeming invitation", err)
+		http.Error(w, "Error redeeming invitation", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := getUserInfo(user.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
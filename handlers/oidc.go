@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"casbin-demo/database"
+	"casbin-demo/enforcer"
+	"casbin-demo/models"
+	"casbin-demo/oidc"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// OIDCLoginHandler redirects the browser to the IdP with a PKCE challenge
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider := oidc.GetProvider()
+	if provider == nil {
+		http.Error(w, "OIDC login is not configured", http.StatusNotFound)
+		return
+	}
+
+	state, session, err := oidc.StartLogin()
+	if err != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthCodeURL(state, session.Nonce, oidc.CodeChallenge(session.CodeVerifier)), http.StatusFound)
+}
+
+// OIDCCallbackHandler completes the code flow, provisions the user and issues a session token
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider := oidc.GetProvider()
+	if provider == nil {
+		http.Error(w, "OIDC login is not configured", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "Login failed: "+errCode, http.StatusUnauthorized)
+		return
+	}
+
+	session, ok := oidc.FinishLogin(query.Get("state"))
+	if !ok {
+		http.Error(w, "Invalid or expired login state", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := provider.Exchange(query.Get("code"), session.CodeVerifier, session.Nonce)
+	if err != nil {
+		fmt.Println("Error verifying OIDC login", err)
+		http.Error(w, "Invalid login", http.StatusUnauthorized)
+		return
+	}
+
+	fmt.Println("Logging in OIDC user", identity.Username)
+	user, err := provisionUser(identity)
+	if err == database.ErrUsernameTaken {
+		http.Error(w, fmt.Sprintf("Username %s belongs to another account", identity.Username), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error provisioning user", err)
+		http.Error(w, "Error provisioning user", http.StatusInternalServerError)
+		return
+	}
+
+	if user.DeletedAt != nil {
+		http.Error(w, "Account deleted", http.StatusForbidden)
+		return
+	}
+	if user.Status == models.UserStatusSuspended {
+		http.Error(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	if err := applyGroupMapping(provider.Mapping(), user.Username, identity.Groups); err != nil {
+		fmt.Println("Error applying group mapping", err)
+		http.Error(w, "Error applying group mapping", http.StatusInternalServerError)
+		return
+	}
+
+	tokenString, err := generateToken(user.Username, user.ID)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
+}
+
+// provisionUser returns the local user bound to an IdP account, creating it
+// on first login. Accounts are matched on issuer and subject only; an existing
+// local account with the same username or email is never taken over.
+// Provisioned users get an unguessable password so they can only sign in through the IdP.
+func provisionUser(identity *oidc.Identity) (models.User, error) {
+	user, err := database.GetUserByIdentity(identity.Issuer, identity.Subject)
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+
+	// A username that names a role or subject in the policy would inherit
+	// the rules written for it
+	inPolicy, err := enforcer.IsPolicyName(identity.Username)
+	if err != nil {
+		return user, err
+	}
+	if inPolicy {
+		return user, database.ErrUsernameTaken
+	}
+
+	password, err := oidc.RandomString(32)
+	if err != nil {
+		return user, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return user, err
+	}
+
+	user, err = database.CreateIdentityUser(identity.Username, string(hashedPassword), identity.Issuer, identity.Subject)
+	if err != nil {
+		return user, err
+	}
+	return user, assignDefaultGroup(user.Username)
+}
+
+// applyGroupMapping syncs the IdP-managed Casbin groups with the user's current claims
+func applyGroupMapping(mapping *oidc.GroupMapping, username string, groups []string) error {
+	grant, revoke := mapping.Resolve(groups)
+	if len(grant) == 0 && len(revoke) == 0 {
+		return nil
+	}
+
+	e, unlock := enforcer.LockForUpdate()
+	defer unlock()
+	for _, group := range grant {
+		if _, err := e.AddGroupingPolicy(username, group); err != nil {
+			return err
+		}
+	}
+	for _, group := range revoke {
+		if _, err := e.RemoveGroupingPolicy(username, group); err != nil {
+			return err
+		}
+	}
+
+	return e.SavePolicy()
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"casbin-demo/database"
+	"casbin-demo/enforcer"
+	"casbin-demo/oidc"
+	"casbin-demo/oidc/oidctest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var userRowColumns = []string{"id", "username", "password", "email", "full_name", "status", "deleted_at"}
+
+// oidcLogin starts a login against a mock IdP and returns the callback request
+// the browser would make after the user signs in as subject
+func oidcLogin(t *testing.T, subject, username string) (*oidctest.Server, *http.Request) {
+	t.Helper()
+
+	useTestPolicy(t)
+	idp := oidctest.NewServer("inventory")
+	t.Cleanup(idp.Close)
+
+	provider, err := oidc.NewProvider(oidc.Config{
+		Issuer:        idp.Issuer(),
+		ClientID:      "inventory",
+		RedirectURL:   "https://inventory.example.com/auth/oidc/callback",
+		Scopes:        []string{"openid"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		MappingFile:   t.TempDir() + "/none.json",
+	}, idp.Client())
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	oidc.GlobalProvider = provider
+	t.Cleanup(func() { oidc.GlobalProvider = nil })
+
+	rec := httptest.NewRecorder()
+	OIDCLoginHandler(rec, httptest.NewRequest("GET", "/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login returned %d, want a redirect", rec.Code)
+	}
+
+	claims := idp.Claims(subject)
+	claims["preferred_username"] = username
+	callback, err := idp.Authorize(rec.Header().Get("Location"), claims)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	return idp, httptest.NewRequest("GET", "/auth/oidc/callback?"+callback.Encode(), nil)
+}
+
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.SetDB(conn)
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+		database.SetDB(nil)
+	})
+	return mock
+}
+
+func callback(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	OIDCCallbackHandler(rec, req)
+	return rec
+}
+
+func TestOIDCCallbackProvisionsNewIdentity(t *testing.T) {
+	idp, req := oidcLogin(t, "subject-1", "alice")
+	mock := mockDB(t)
+
+	mock.ExpectQuery("FROM users WHERE oidc_issuer=\\$1 AND oidc_subject=\\$2").
+		WithArgs(idp.Issuer(), "subject-1").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs("alice", sqlmock.AnyArg(), idp.Issuer(), "subject-1").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(7, "alice", "hash", "", "", "active", nil))
+
+	rec := callback(req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", rec.Code, rec.Body)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body["token"] == "" {
+		t.Errorf("callback body %v has no token", body)
+	}
+}
+
+func TestOIDCCallbackUsesBoundAccount(t *testing.T) {
+	idp, req := oidcLogin(t, "subject-1", "renamed-at-idp")
+	mock := mockDB(t)
+
+	// The IdP username changed; the account is still found by issuer and subject
+	mock.ExpectQuery("FROM users WHERE oidc_issuer").
+		WithArgs(idp.Issuer(), "subject-1").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(7, "alice", "hash", "", "", "active", nil))
+
+	if rec := callback(req); rec.Code != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackNeverTakesOverLocalAccount(t *testing.T) {
+	idp, req := oidcLogin(t, "attacker-subject", "admin")
+	mock := mockDB(t)
+
+	mock.ExpectQuery("FROM users WHERE oidc_issuer").
+		WithArgs(idp.Issuer(), "attacker-subject").
+		WillReturnError(sql.ErrNoRows)
+	// The local admin account exists, so the guarded insert creates nothing
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs("admin", sqlmock.AnyArg(), idp.Issuer(), "attacker-subject").
+		WillReturnRows(sqlmock.NewRows(userRowColumns))
+
+	if rec := callback(req); rec.Code != http.StatusConflict {
+		t.Fatalf("callback returned %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+}
+
+func TestOIDCCallbackRefusesPolicyNames(t *testing.T) {
+	idp, req := oidcLogin(t, "attacker-subject", enforcer.RootRole)
+	mock := mockDB(t)
+
+	mock.ExpectQuery("FROM users WHERE oidc_issuer").
+		WithArgs(idp.Issuer(), "attacker-subject").
+		WillReturnError(sql.ErrNoRows)
+
+	if rec := callback(req); rec.Code != http.StatusConflict {
+		t.Fatalf("callback returned %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+}
+
+func TestOIDCCallbackRejectsInactiveAccounts(t *testing.T) {
+	deletedAt := time.Now()
+	tests := []struct {
+		name      string
+		status    string
+		deletedAt *time.Time
+	}{
+		{"suspended", "suspended", nil},
+		{"deleted", "active", &deletedAt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, req := oidcLogin(t, "subject-1", "alice")
+			mock := mockDB(t)
+
+			mock.ExpectQuery("FROM users WHERE oidc_issuer").
+				WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(7, "alice", "hash", "", "", tt.status, tt.deletedAt))
+
+			if rec := callback(req); rec.Code != http.StatusForbidden {
+				t.Fatalf("callback returned %d, want %d", rec.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsReplayedState(t *testing.T) {
+	idp, req := oidcLogin(t, "subject-1", "alice")
+	mock := mockDB(t)
+
+	mock.ExpectQuery("FROM users WHERE oidc_issuer").
+		WithArgs(idp.Issuer(), "subject-1").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(7, "alice", "hash", "", "", "active", nil))
+
+	if rec := callback(req); rec.Code != http.StatusOK {
+		t.Fatalf("first callback returned %d: %s", rec.Code, rec.Body)
+	}
+	if rec := callback(httptest.NewRequest("GET", req.URL.String(), nil)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback returned %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -4,12 +4,63 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
+	"casbin-demo/middlewares"
 	"casbin-demo/models"
 
 	"casbin-demo/enforcer"
+
+	"github.com/gorilla/mux"
 )
 
+func toPermissionRule(rule []string) models.PermissionRule {
+	conditions := enforcer.PadRule(rule[min(len(rule), 4):], 3)
+	return models.PermissionRule{
+		ID:      enforcer.RuleID(rule),
+		Subject: rule[0],
+		Object:  rule[1],
+		Action:  rule[2],
+		Effect:  rule[3],
+		CIDR:    conditions[0],
+		Hours:   conditions[1],
+		Days:    conditions[2],
+	}
+}
+
+// authorizeRuleChange rejects the request unless the caller may grant or revoke the rule
+func authorizeRuleChange(w http.ResponseWriter, r *http.Request, rule []string) bool {
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return false
+	}
+
+	if err := enforcer.CheckGrant(middlewares.RequestEnvironment(r), claims.Username, rule[0], rule[1], rule[2]); err != nil {
+		if _, ok := err.(*enforcer.ScopeError); ok {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return false
+		}
+		http.Error(w, "Authorization error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// findRule looks up a policy rule by its ID
+func findRule(id string) ([]string, error) {
+	rules, err := enforcer.GetEnforcer().GetPolicy()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if enforcer.RuleID(rule) == id {
+			return rule, nil
+		}
+	}
+	return nil, nil
+}
+
 func GrantPermission(w http.ResponseWriter, r *http.Request) {
 	var req models.PermissionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -17,15 +68,31 @@ func GrantPermission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	enforcer := enforcer.GetEnforcer()
+	rule := enforcer.NormalizeRule(req.Rule())
+	if err := enforcer.ValidateRule(rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !authorizeRuleChange(w, r, rule) {
+		return
+	}
+
+	enforcer, unlock := enforcer.LockForUpdate()
+	defer unlock()
 
 	fmt.Println("Granting permission to", req.Subject, "for", req.Object, "to", req.Action, "with effect", req.Effect)
-	_, err := enforcer.AddPolicy(req.Subject, req.Object, req.Action, req.Effect)
+	added, err := enforcer.AddPolicy(rule)
 	if err != nil {
 		http.Error(w, "Failed to grant permission", http.StatusInternalServerError)
 		return
 	}
 
+	if !added {
+		http.Error(w, "Permission already exists", http.StatusConflict)
+		return
+	}
+
 	err = enforcer.SavePolicy()
 	if err != nil {
 		http.Error(w, "Failed to save policy", http.StatusInternalServerError)
@@ -33,4 +100,188 @@ func GrantPermission(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toPermissionRule(rule))
+}
+
+// ListPermissions lists policy rules filtered by subject, object, action and effect
+func ListPermissions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(query.Get("page_size"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		pageSize = 50
+	}
+
+	// An empty filter value matches any field value
+	rules, err := enforcer.GetEnforcer().GetFilteredPolicy(0,
+		query.Get("subject"), query.Get("object"), query.Get("action"), query.Get("effect"))
+	if err != nil {
+		http.Error(w, "Failed to get permissions", http.StatusInternalServerError)
+		return
+	}
+
+	start := min((page-1)*pageSize, len(rules))
+	end := min(start+pageSize, len(rules))
+
+	response := models.PermissionListResponse{
+		Rules:    make([]models.PermissionRule, 0, end-start),
+		Page:     page,
+		PageSize: pageSize,
+		Total:    len(rules),
+	}
+	for _, rule := range rules[start:end] {
+		response.Rules = append(response.Rules, toPermissionRule(rule))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokePermission removes a single rule identified by its full tuple
+func RevokePermission(w http.ResponseWriter, r *http.Request) {
+	var req models.PermissionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	revokeRule(w, r, enforcer.NormalizeRule(req.Rule()))
+}
+
+// RevokePermissionByID removes a single rule identified by its ID
+func RevokePermissionByID(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	rule, err := findRule(vars["id"])
+	if err != nil {
+		http.Error(w, "Failed to get permissions", http.StatusInternalServerError)
+		return
+	}
+	if rule == nil {
+		http.Error(w, "Permission not found", http.StatusNotFound)
+		return
+	}
+
+	revokeRule(w, r, rule)
+}
+
+func revokeRule(w http.ResponseWriter, r *http.Request, rule []string) {
+	if err := enforcer.ValidateRule(rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !authorizeRuleChange(w, r, rule) {
+		return
+	}
+
+	e, unlock := enforcer.LockForUpdate()
+	defer unlock()
+
+	fmt.Println("Revoking permission", rule)
+	removed, err := e.RemovePolicy(rule)
+	if err != nil {
+		http.Error(w, "Failed to revoke permission", http.StatusInternalServerError)
+		return
+	}
+
+	if !removed {
+		http.Error(w, "Permission not found", http.StatusNotFound)
+		return
+	}
+
+	if err := e.SavePolicy(); err != nil {
+		http.Error(w, "Failed to save policy", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdatePermission replaces a rule identified by its full tuple
+func UpdatePermission(w http.ResponseWriter, r *http.Request) {
+	var req models.PermissionUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	updateRule(w, r, enforcer.NormalizeRule(req.Old.Rule()), enforcer.NormalizeRule(req.New.Rule()))
+}
+
+// UpdatePermissionByID replaces a rule identified by its ID
+func UpdatePermissionByID(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req models.PermissionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := findRule(vars["id"])
+	if err != nil {
+		http.Error(w, "Failed to get permissions", http.StatusInternalServerError)
+		return
+	}
+	if rule == nil {
+		http.Error(w, "Permission not found", http.StatusNotFound)
+		return
+	}
+
+	updateRule(w, r, rule, enforcer.NormalizeRule(req.Rule()))
+}
+
+func updateRule(w http.ResponseWriter, r *http.Request, oldRule, newRule []string) {
+	for _, rule := range [][]string{oldRule, newRule} {
+		if err := enforcer.ValidateRule(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The caller must be able to revoke the old rule and grant the new one
+	if !authorizeRuleChange(w, r, oldRule) || !authorizeRuleChange(w, r, newRule) {
+		return
+	}
+
+	e, unlock := enforcer.LockForUpdate()
+	defer unlock()
+
+	exists, err := e.HasPolicy(oldRule)
+	if err != nil {
+		http.Error(w, "Failed to get permissions", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Permission not found", http.StatusNotFound)
+		return
+	}
+
+	duplicate, err := e.HasPolicy(newRule)
+	if err != nil {
+		http.Error(w, "Failed to get permissions", http.StatusInternalServerError)
+		return
+	}
+	if duplicate {
+		http.Error(w, "Permission already exists", http.StatusConflict)
+		return
+	}
+
+	fmt.Println("Updating permission", oldRule, "to", newRule)
+	if _, err := e.UpdatePolicy(oldRule, newRule); err != nil {
+		http.Error(w, "Failed to update permission", http.StatusInternalServerError)
+		return
+	}
+
+	if err := e.SavePolicy(); err != nil {
+		http.Error(w, "Failed to save policy", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(toPermissionRule(newRule))
 }
@@ -2,17 +2,65 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 
 	"casbin-demo/database"
 	"casbin-demo/middlewares"
 	"casbin-demo/models"
+	"casbin-demo/ratelimit"
+	"casbin-demo/routes"
 
 	"github.com/gorilla/mux"
 )
 
+// writeProductError maps a failed product or stock change to its HTTP status
+func writeProductError(w http.ResponseWriter, err error) {
+	switch err {
+	case database.ErrProductNotFound, sql.ErrNoRows:
+		http.Error(w, "Product not found", http.StatusNotFound)
+	case database.ErrInsufficientStock:
+		http.Error(w, "Insufficient stock", http.StatusConflict)
+	case database.ErrVersionMismatch:
+		http.Error(w, "Product was modified; fetch it again", http.StatusPreconditionFailed)
+	case database.ErrDuplicateSKU, database.ErrDuplicateBarcode:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case database.ErrCategoryNotFound, database.ErrUnknownUnit, database.ErrWarehouseNotFound, database.ErrBinNotFound:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// convertStockUnit converts a stock request given in one of the product's
+// purchase units to base units, answering the request itself when that fails
+func convertStockUnit(w http.ResponseWriter, productID int, req *models.StockRequest) bool {
+	if req.Unit == "" {
+		return true
+	}
+
+	factor, err := database.GetProductUnitFactor(productID, req.Unit)
+	if err != nil {
+		writeProductError(w, err)
+		return false
+	}
+
+	quantity, ok := baseQuantity(req.Quantity, factor)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%d %s is not a whole number of base units", req.Quantity, req.Unit), http.StatusBadRequest)
+		return false
+	}
+	req.Quantity = quantity
+	req.UnitCost /= factor
+	return true
+}
+
 // AddStock handles increasing product stock
 func AddStock(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -32,6 +80,20 @@ func AddStock(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Quantity must be positive", http.StatusBadRequest)
 		return
 	}
+	if req.UnitCost < 0 {
+		http.Error(w, "Unit cost cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if !convertStockUnit(w, productID, &req) {
+		return
+	}
+	if !resolveBin(w, req.BinID, &req.WarehouseID) {
+		return
+	}
+	warehouse, ok := useWarehouse(w, r, req.WarehouseID)
+	if !ok {
+		return
+	}
 
 	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
 	if !ok {
@@ -40,17 +102,32 @@ func AddStock(w http.ResponseWriter, r *http.Request) {
 	}
 
 	op := models.Operation{
-		ProductID: productID,
-		Quantity:  req.Quantity,
-		UserID:    claims.UserID,
+		ProductID:   productID,
+		Quantity:    req.Quantity,
+		Reason:      req.Reason,
+		UnitCost:    req.UnitCost,
+		UserID:      claims.UserID,
+		ActorID:     claims.ActorID,
+		WarehouseID: warehouse.ID,
+		BinID:       req.BinID,
 	}
 
 	if err := database.AddProductStock(op); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProductError(w, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"message": "Stock added successfully"})
+	// Stock added without a bin waits unassigned; suggest where to put it away
+	response := map[string]interface{}{"message": "Stock added successfully"}
+	if req.BinID == 0 {
+		bins, err := database.SuggestBins(productID, warehouse.ID, putAwaySuggestions)
+		if err != nil {
+			fmt.Println("Failed to suggest bins:", err)
+		} else {
+			response["suggested_bins"] = bins
+		}
+	}
+	json.NewEncoder(w).Encode(response)
 }
 
 // RemoveStock handles decreasing product stock
@@ -72,6 +149,16 @@ func RemoveStock(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Quantity must be positive", http.StatusBadRequest)
 		return
 	}
+	if !convertStockUnit(w, productID, &req) {
+		return
+	}
+	if !resolveBin(w, req.BinID, &req.WarehouseID) {
+		return
+	}
+	warehouse, ok := useWarehouse(w, r, req.WarehouseID)
+	if !ok {
+		return
+	}
 
 	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
 	if !ok {
@@ -79,21 +166,44 @@ func RemoveStock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Count the quantity against the user's daily quota before removing it
+	limiter := ratelimit.GetLimiter()
+	permission, _ := routes.GetRegistry().Permission(r)
+	if limiter != nil {
+		result, err := limiter.TakeQuantity(claims.Username, permission, int64(req.Quantity))
+		if err != nil {
+			http.Error(w, "Rate limit error", http.StatusInternalServerError)
+			return
+		}
+		if !result.Allowed {
+			middlewares.TooManyRequests(w, result, "Daily quantity quota exceeded")
+			return
+		}
+	}
+
 	op := models.Operation{
-		ProductID: productID,
-		Quantity:  req.Quantity,
-		UserID:    claims.UserID,
+		ProductID:   productID,
+		Quantity:    req.Quantity,
+		Reason:      req.Reason,
+		UserID:      claims.UserID,
+		ActorID:     claims.ActorID,
+		WarehouseID: warehouse.ID,
+		BinID:       req.BinID,
 	}
 
 	if err := database.RemoveProductStock(op); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if limiter != nil {
+			limiter.ReturnQuantity(claims.Username, permission, int64(req.Quantity))
+		}
+		writeProductError(w, err)
 		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]string{"message": "Stock removed successfully"})
 }
 
-// AdjustStocks handles adjusting product
+// UpdateProduct changes a product. If-Match must carry the ETag the client
+// last read, so concurrent edits fail with 412 instead of overwriting each other.
 func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID, err := strconv.Atoi(vars["productId"])
@@ -102,12 +212,35 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req models.ProductRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	versions, ok := expectedVersions(w, r)
+	if !ok {
+		return
+	}
+
+	var update models.ProductUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	current, err := database.GetProductByID(productID)
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	if err := validateProductUpdate(&update, current); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A quantity change is counted in one warehouse the user works in
+	if update.Quantity != nil {
+		warehouse, ok := useWarehouse(w, r, update.WarehouseID)
+		if !ok {
+			return
+		}
+		update.WarehouseID = warehouse.ID
+	}
+
 	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
 	if !ok {
 		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
@@ -116,42 +249,209 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 
 	op := models.Operation{
 		ProductID: productID,
-		Quantity:  req.Quantity,
-		Reason:    req.Reason,
+		Reason:    update.Reason,
 		UserID:    claims.UserID,
+		ActorID:   claims.ActorID,
+		Versions:  versions,
 	}
 
-	if err := database.UpdateProduct(op); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	version, err := database.UpdateProduct(op, update)
+	if err != nil {
+		writeProductError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", productETag(version))
 	json.NewEncoder(w).Encode(map[string]string{"message": "Stock adjusted successfully"})
 }
 
+const (
+	defaultProductPageSize = 50
+	maxProductPageSize     = 200
+)
+
+// GetAllProducts lists products a page at a time. The next_cursor of a page,
+// also linked from the Link header, continues the listing with the same
+// filters and sort.
 func GetAllProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := database.GetAllProducts()
+	q, err := parseProductQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	listProducts(w, r, q)
+}
+
+// GetProductTrash lists deleted products that can still be restored. It
+// takes the same parameters as the product listing, except deleted.
+func GetProductTrash(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	values.Del("deleted")
+
+	q, err := parseProductQuery(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.OnlyDeleted = true
+
+	listProducts(w, r, q)
+}
+
+func listProducts(w http.ResponseWriter, r *http.Request, q models.ProductQuery) {
+	page, err := database.GetAllProducts(q)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// Return empty array instead of null when no products found
-			json.NewEncoder(w).Encode([]models.Product{})
-			return
-		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var response []models.ProductResponse
-	for _, p := range products {
-		response = append(response, models.ProductResponse{
-			ID:        p.ID,
-			Name:      p.Name,
-			Quantity:  p.Quantity,
-			UnitPrice: p.UnitPrice,
-		})
+	response := models.ProductListResponse{
+		Products: make([]models.ProductResponse, 0, len(page.Products)),
+		Limit:    q.Limit,
+		Total:    page.Total,
+		HasMore:  page.Next != nil,
+	}
+	for _, p := range page.Products {
+		response.Products = append(response.Products, toProductResponse(p))
 	}
 
-	json.NewEncoder(w).Encode(response)
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, pageURL(r, ""))}
+	if page.Next != nil {
+		response.NextCursor = encodeProductCursor(*page.Next)
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(r, response.NextCursor)))
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+	if notModified(w, r, bodyETag(data)) {
+		return
+	}
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// parseProductQuery reads the listing parameters: q (full-text search), name,
+// sku, barcode, category, attr.<name>, min_price, max_price, min_quantity,
+// max_quantity, deleted (true, false or all), sort, order (asc or desc),
+// limit and cursor
+func parseProductQuery(values url.Values) (models.ProductQuery, error) {
+	q := models.ProductQuery{
+		Search:  values.Get("q"),
+		Name:    values.Get("name"),
+		SKU:     strings.ToUpper(values.Get("sku")),
+		Barcode: values.Get("barcode"),
+		Sort:    values.Get("sort"),
+		Limit:   defaultProductPageSize,
+	}
+
+	if value := values.Get("category"); value != "" {
+		categoryID, err := strconv.Atoi(value)
+		if err != nil {
+			return q, fmt.Errorf("category must be a category ID")
+		}
+		q.CategoryID = &categoryID
+	}
+	for name := range values {
+		if key, ok := strings.CutPrefix(name, "attr."); ok && key != "" {
+			if q.Attributes == nil {
+				q.Attributes = map[string]string{}
+			}
+			q.Attributes[key] = values.Get(name)
+		}
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxProductPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxProductPageSize)
+		}
+		q.Limit = limit
+	}
+
+	for name, target := range map[string]**float64{"min_price": &q.MinPrice, "max_price": &q.MaxPrice} {
+		if value := values.Get(name); value != "" {
+			price, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return q, fmt.Errorf("%s must be a number", name)
+			}
+			*target = &price
+		}
+	}
+	for name, target := range map[string]**int{"min_quantity": &q.MinQuantity, "max_quantity": &q.MaxQuantity} {
+		if value := values.Get(name); value != "" {
+			quantity, err := strconv.Atoi(value)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an integer", name)
+			}
+			*target = &quantity
+		}
+	}
+
+	switch values.Get("deleted") {
+	case "", "false":
+	case "true":
+		q.OnlyDeleted = true
+	case "all":
+		q.IncludeDeleted = true
+	default:
+		return q, fmt.Errorf("deleted must be true, false or all")
+	}
+
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	if !slices.Contains(models.ProductSortColumns, q.Sort) {
+		return q, fmt.Errorf("sort must be one of %s", strings.Join(models.ProductSortColumns, ", "))
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("order must be asc or desc")
+	}
+
+	if value := values.Get("cursor"); value != "" {
+		cursor, err := decodeProductCursor(value)
+		if err != nil || cursor.Sort != q.Sort || cursor.Desc != q.Desc {
+			return q, fmt.Errorf("invalid cursor for this sort order")
+		}
+		q.After = &cursor
+	}
+	return q, nil
+}
+
+func encodeProductCursor(cursor models.ProductCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeProductCursor(value string) (models.ProductCursor, error) {
+	var cursor models.ProductCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+// pageURL is the request URL with its cursor replaced; an empty cursor gives the first page
+func pageURL(r *http.Request, cursor string) string {
+	values := r.URL.Query()
+	values.Del("cursor")
+	if cursor != "" {
+		values.Set("cursor", cursor)
+	}
+
+	u := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+	return u.String()
 }
 
 func CreateProduct(w http.ResponseWriter, r *http.Request) {
@@ -168,36 +468,75 @@ func CreateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate request
-	if req.Name == "" {
-		http.Error(w, "Product name is required", http.StatusBadRequest)
+	if err := validateProductRequest(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if req.UnitPrice <= 0 {
-		http.Error(w, "Unit price must be greater than 0", http.StatusBadRequest)
+	warehouse, ok := useWarehouse(w, r, req.WarehouseID)
+	if !ok {
+		return
+	}
+	req.WarehouseID = warehouse.ID
+
+	op := models.Operation{
+		UserID:  claims.UserID,
+		ActorID: claims.ActorID,
+		Type:    models.OperationAddProduct,
+		Reason:  "Initial product creation",
+	}
+
+	productID, err := database.CreateProduct(op, req)
+	if err != nil {
+		writeProductError(w, err)
 		return
 	}
-	if req.Quantity < 0 {
-		http.Error(w, "Quantity cannot be negative", http.StatusBadRequest)
+
+	w.Header().Set("Location", fmt.Sprintf("/products/%d", productID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// RestoreProduct takes a product out of the trash
+func RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["productId"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
 		return
 	}
 
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return
+	}
+
+	// If-Match is optional here; a deleted product cannot be edited meanwhile
 	op := models.Operation{
-		Name:      req.Name,
-		UnitPrice: req.UnitPrice,
-		Quantity:  req.Quantity,
+		ProductID: productID,
 		UserID:    claims.UserID,
-		Type:      models.OperationAddProduct,
-		Reason:    "Initial product creation",
+		ActorID:   claims.ActorID,
+	}
+	if r.Header.Get("If-Match") != "" {
+		if op.Versions, ok = expectedVersions(w, r); !ok {
+			return
+		}
 	}
 
-	if err := database.CreateProduct(op); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	version, err := database.RestoreProduct(op)
+	if err != nil {
+		if err == database.ErrProductNotDeleted {
+			http.Error(w, "Product is not deleted", http.StatusConflict)
+			return
+		}
+		writeProductError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	w.Header().Set("ETag", productETag(version))
+	json.NewEncoder(w).Encode(map[string]string{"message": "Product restored successfully"})
 }
 
+// DeleteProduct soft deletes a product. Like updates it requires If-Match.
 func DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID, err := strconv.Atoi(vars["productId"])
@@ -206,6 +545,11 @@ func DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	versions, ok := expectedVersions(w, r)
+	if !ok {
+		return
+	}
+
 	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
 	if !ok {
 		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
@@ -215,16 +559,14 @@ func DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	op := models.Operation{
 		ProductID: productID,
 		UserID:    claims.UserID,
+		ActorID:   claims.ActorID,
 		Reason:    "Product deletion",
 		Type:      models.OperationDelProduct,
+		Versions:  versions,
 	}
 
 	if err := database.DeleteProduct(op); err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Product not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProductError(w, err)
 		return
 	}
 
@@ -249,14 +591,32 @@ func GetProductByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := models.ProductResponse{
-		ID:        product.ID,
-		Name:      product.Name,
-		Quantity:  product.Quantity,
-		UnitPrice: product.UnitPrice,
+	if notModified(w, r, productETag(product.Version)) {
+		return
 	}
 
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(toProductResponse(product))
+}
+
+// GetProductByBarcode looks a product up by one of its barcodes, e.g. from a scanner
+func GetProductByBarcode(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	product, err := database.GetProductByBarcode(vars["barcode"])
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if notModified(w, r, productETag(product.Version)) {
+		return
+	}
+
+	json.NewEncoder(w).Encode(toProductResponse(product))
 }
 
 func GetProductsReport(w http.ResponseWriter, r *http.Request) {
@@ -272,3 +632,18 @@ func GetProductsReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// GetStockReconciliation lists products whose quantity differs from the sum of their ledger
+func GetStockReconciliation(w http.ResponseWriter, r *http.Request) {
+	drifts, err := database.ReconcileStock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":     len(drifts) == 0,
+		"drifts": drifts,
+	})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"casbin-demo/enforcer"
+
+	"github.com/gorilla/mux"
+)
+
+func scimRequest(handler http.HandlerFunc, method, id, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/scim/v2/Groups/"+id, strings.NewReader(body))
+	if id != "" {
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestSCIMLeavesRootRoleAlone(t *testing.T) {
+	useTestPolicy(t)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		id      string
+		body    string
+	}{
+		{"create", SCIMCreateGroup, "POST", "", `{"displayName":"root","members":[{"value":"7"}]}`},
+		{"replace", SCIMReplaceGroup, "PUT", "root", `{"displayName":"root","members":[]}`},
+		{"patch", SCIMPatchGroup, "PATCH", "root", `{"Operations":[{"op":"add","path":"members","value":[{"value":"7"}]}]}`},
+		{"delete", SCIMDeleteGroup, "DELETE", "root", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := scimRequest(tt.handler, tt.method, tt.id, tt.body); rec.Code != http.StatusForbidden {
+				t.Errorf("%s root returned %d, want 403: %s", tt.method, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	members, err := enforcer.GetEnforcer().GetUsersForRole(enforcer.RootRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0] != "rootuser" {
+		t.Errorf("root members = %v, want [rootuser]", members)
+	}
+}
+
+func TestSCIMRejectsGroupsAsMembers(t *testing.T) {
+	useTestPolicy(t)
+
+	bodies := []string{
+		`{"Operations":[{"op":"add","path":"members","value":[{"value":"manager"}]}]}`,
+		`{"Operations":[{"op":"add","value":{"members":[{"value":"manager"}]}}]}`,
+		`{"Operations":[{"op":"remove","path":"members[value eq \"manager\"]"}]}`,
+	}
+	for _, body := range bodies {
+		if rec := scimRequest(SCIMPatchGroup, "PATCH", "staff", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("PATCH %s returned %d, want 400", body, rec.Code)
+		}
+	}
+	if rec := scimRequest(SCIMReplaceGroup, "PUT", "staff", `{"members":[{"value":"manager"}]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT returned %d, want 400", rec.Code)
+	}
+
+	if ok, _ := enforcer.GetEnforcer().HasGroupingPolicy("manager", "staff"); ok {
+		t.Error("manager was nested under staff")
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"casbin-demo/database"
+	"casbin-demo/enforcer"
+	"casbin-demo/middlewares"
+	"casbin-demo/models"
+	"casbin-demo/routes"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestStockLoad hammers one scratch product with concurrent stock changes and
+// retried idempotent requests against a real database, then checks that stock
+// never went negative, no change was lost or applied twice, and the product
+// still reconciles with its ledger. It needs Postgres, configured through the
+// DB_* variables or a .env file, and is skipped when none is reachable.
+func TestStockLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("needs a database")
+	}
+	if err := database.InitializeDatabase(); err != nil {
+		t.Skip("no database:", err)
+	}
+	user := scratchUser(t, "staff")
+
+	const (
+		workers  = 16
+		requests = 50
+		initial  = 100
+	)
+
+	productID, err := database.CreateProduct(models.Operation{
+		UserID: user.ID,
+		Type:   models.OperationAddProduct,
+		Reason: "stock load test",
+	}, models.ProductRequest{
+		Name:      "stock load test product",
+		SKU:       fmt.Sprintf("LOAD-TEST-%d", time.Now().UnixNano()),
+		UnitPrice: 1,
+		Quantity:  initial,
+		BaseUnit:  models.DefaultBaseUnit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.DeleteProduct(models.Operation{
+			ProductID: productID,
+			UserID:    user.ID,
+			Type:      models.OperationDelProduct,
+			Reason:    "stock load test",
+		})
+	})
+
+	// Even workers remove two units at a time and odd workers add one, so
+	// removals keep running into an empty shelf
+	var added, removed, rejected atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for n := 0; n < requests; n++ {
+				op := models.Operation{ProductID: productID, UserID: user.ID, Reason: "stock load test"}
+				if worker%2 == 0 {
+					op.Quantity = 2
+					err := database.RemoveProductStock(op)
+					switch err {
+					case nil:
+						removed.Add(2)
+					case database.ErrInsufficientStock:
+						rejected.Add(1)
+					default:
+						t.Error(err)
+						return
+					}
+					continue
+				}
+				op.Quantity = 1
+				if err := database.AddProductStock(op); err != nil {
+					t.Error(err)
+					return
+				}
+				added.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	product, err := database.GetProductByID(productID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("stock changes: added=%d removed=%d rejected=%d quantity=%d", added.Load(), removed.Load(), rejected.Load(), product.Quantity)
+	if expected := initial + added.Load() - removed.Load(); int64(product.Quantity) != expected {
+		t.Fatalf("quantity is %d, expected %d", product.Quantity, expected)
+	}
+	if product.Quantity < 0 {
+		t.Fatalf("quantity went negative: %d", product.Quantity)
+	}
+
+	// Every worker sends the same stock-in with the same key; it must be applied once
+	statuses := sendIdempotent(t, productID, user, workers)
+	after, err := database.GetProductByID(productID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("idempotent retries: statuses=%v quantity=%d", statuses, after.Quantity)
+	if after.Quantity != product.Quantity+5 {
+		t.Errorf("idempotent stock-in changed quantity by %d, expected 5", after.Quantity-product.Quantity)
+	}
+	if statuses[http.StatusOK] == 0 {
+		t.Error("no idempotent request succeeded")
+	}
+
+	drifts, err := database.ReconcileStock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, drift := range drifts {
+		if drift.ProductID == productID {
+			t.Errorf("product drifted from its ledger by %+d", drift.Drift)
+		}
+	}
+}
+
+// useTestPolicy loads the shipped model and policy from a temporary copy so
+// nothing a test saves reaches the config directory
+func useTestPolicy(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for env, name := range map[string]string{"CASBIN_MODEL_PATH": "pbac_model.conf", "CASBIN_POLICY_PATH": "policy.csv"} {
+		data, err := os.ReadFile(filepath.Join("..", "config", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(env, path)
+	}
+	if err := enforcer.InitializeEnforcer(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// scratchUser creates a user in group for the test, with the shipped policy
+// loaded from a temporary copy so the group assignment is never saved
+func scratchUser(t *testing.T, group string) models.User {
+	t.Helper()
+
+	useTestPolicy(t)
+
+	username := fmt.Sprintf("load-test-%d", time.Now().UnixNano())
+	password, err := bcrypt.GenerateFromPassword([]byte(username), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.CreateUser(username, string(password)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.SoftDeleteUser(username, nil) })
+
+	if _, err := enforcer.GetEnforcer().AddGroupingPolicy(username, group); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// sendIdempotent sends concurrent copies of one stock-in request with the
+// same Idempotency-Key through the real handler, plus one retry after they
+// finish, and counts the response statuses
+func sendIdempotent(t *testing.T, productID int, user models.User, copies int) map[int]int {
+	// The route carries the permission the warehouse scope is checked against
+	router := mux.NewRouter()
+	routes.GetRegistry().Handle(router, "PATCH", "/products/{productId}/stocks/in", "stock:in", middlewares.Idempotent(AddStock))
+	key := fmt.Sprintf("load-test-%d", productID)
+	claims := &models.Claims{Username: user.Username, UserID: user.ID}
+
+	send := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PATCH", "/products/"+strconv.Itoa(productID)+"/stocks/in", strings.NewReader(`{"quantity": 5}`))
+		r.Header.Set(middlewares.IdempotencyKeyHeader, key)
+		r = r.WithContext(context.WithValue(r.Context(), middlewares.ClaimsKey, claims))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	var mu sync.Mutex
+	statuses := map[int]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < copies; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := send()
+			mu.Lock()
+			statuses[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	retry := send()
+	if retry.Code != http.StatusOK || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry after completion got %d without a replayed response", retry.Code)
+	}
+	return statuses
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"casbin-demo/models"
@@ -21,19 +24,82 @@ import (
 
 var (
 	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+
+	// defaultUserGroup is assigned to users created without an invitation
+	defaultUserGroup = os.Getenv("DEFAULT_USER_GROUP")
 )
 
+// validateCredentials checks the username and password rules for local accounts
+func validateCredentials(username, password string) error {
+	if len(username) < 4 || len(username) > 32 {
+		return fmt.Errorf("Username must be at least 4 characters and at most 32 characters")
+	}
+
+	if len(password) < 6 || len(password) > 32 {
+		return fmt.Errorf("Password must be at least 6 characters and at most 32 characters")
+	}
+
+	return nil
+}
+
+// requireFreeUsername rejects a new account named after a role or subject in
+// the policy, which would inherit the rules written for that name
+func requireFreeUsername(w http.ResponseWriter, username string) bool {
+	inPolicy, err := enforcer.IsPolicyName(username)
+	if err != nil {
+		http.Error(w, "Authorization error", http.StatusInternalServerError)
+		return false
+	}
+	if inPolicy {
+		http.Error(w, "Username "+username+" is reserved by the access policy", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// requireSubjectScope rejects the request unless the caller may administer the user
+func requireSubjectScope(w http.ResponseWriter, r *http.Request, username string) bool {
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return false
+	}
+
+	allowed, err := enforcer.CanManageSubject(middlewares.RequestEnvironment(r), claims.Username, username)
+	if err != nil {
+		http.Error(w, "Authorization error", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		http.Error(w, username+" is outside your administration scope", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// assignDefaultGroup places a user created without an invitation in the default group
+func assignDefaultGroup(username string) error {
+	if defaultUserGroup == "" {
+		return nil
+	}
+
+	e, unlock := enforcer.LockForUpdate()
+	defer unlock()
+	if _, err := e.AddGroupingPolicy(username, defaultUserGroup); err != nil {
+		return err
+	}
+	return e.SavePolicy()
+}
+
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	json.NewDecoder(r.Body).Decode(&user)
 
-	if len(user.Username) < 4 || len(user.Username) > 32 {
-		http.Error(w, "Username must be at least 4 characters and at most 32 characters", http.StatusBadRequest)
+	if err := validateCredentials(user.Username, user.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	if len(user.Password) < 6 || len(user.Password) > 32 {
-		http.Error(w, "Password must be at least 6 characters and at most 32 characters", http.StatusBadRequest)
+	if !requireFreeUsername(w, user.Username) {
 		return
 	}
 
@@ -50,6 +116,11 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := assignDefaultGroup(user.Username); err != nil {
+		http.Error(w, "Error assigning default group", http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -72,17 +143,12 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	expirationTime := time.Now().Add(time.Hour * 24)
-	claims := &models.Claims{
-		Username: user.Username,
-		UserID:   dbUser.ID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+	if dbUser.Status == models.UserStatusSuspended {
+		http.Error(w, "Account suspended", http.StatusForbidden)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := generateToken(user.Username, dbUser.ID)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
@@ -91,6 +157,21 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
 }
 
+// generateToken signs a session token for a local user
+func generateToken(username string, userID int) (string, error) {
+	expirationTime := time.Now().Add(time.Hour * 24)
+	claims := &models.Claims{
+		Username: username,
+		UserID:   userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
 // getUserInfo is a helper function that gets user info and roles
 func getUserInfo(username string) (*models.UserResponse, error) {
 	user, err := database.GetUserByUsername(username)
@@ -98,17 +179,26 @@ func getUserInfo(username string) (*models.UserResponse, error) {
 		return nil, fmt.Errorf("user not found: %v", err)
 	}
 
+	return toUserResponse(user)
+}
+
+// toUserResponse adds the user's groups to its profile
+func toUserResponse(user models.User) (*models.UserResponse, error) {
 	// Get the enforcer instance and roles
 	e := enforcer.GetEnforcer()
-	roles, err := e.GetRolesForUser(username)
+	roles, err := e.GetRolesForUser(user.Username)
 	if err != nil {
 		return nil, fmt.Errorf("error getting user groups: %v", err)
 	}
 
 	return &models.UserResponse{
-		ID:       user.ID,
-		Username: user.Username,
-		Groups:   roles,
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		FullName:  user.FullName,
+		Status:    user.Status,
+		DeletedAt: user.DeletedAt,
+		Groups:    roles,
 	}, nil
 }
 
@@ -152,23 +242,240 @@ func SoftDeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := deactivateUser(username); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deactivateUser removes a user from Casbin and soft deletes it
+func deactivateUser(username string) error {
 	// Get the enforcer instance
-	e := enforcer.GetEnforcer()
+	e, unlock := enforcer.LockForUpdate()
+	defer unlock()
+
+	// Remember the direct groups so a restore can bring them back
+	groups, err := e.GetRolesForUser(username)
+	if err != nil {
+		return fmt.Errorf("error getting user groups")
+	}
 
 	// Remove user from Casbin (this removes all roles and policies related to the user)
 	_, err = e.DeleteUser(username)
 	if err != nil {
-		http.Error(w, "Error removing user from authorization system", http.StatusInternalServerError)
+		return fmt.Errorf("error removing user from authorization system")
+	}
+
+	if err := e.SavePolicy(); err != nil {
+		return fmt.Errorf("error saving policy")
+	}
+
+	if err := database.SoftDeleteUser(username, groups); err != nil {
+		return fmt.Errorf("error deleting user")
+	}
+
+	return nil
+}
+
+// restoreUser undoes deactivateUser, including the group memberships
+func restoreUser(username string) error {
+	groups, err := database.RestoreUser(username)
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	e, unlock := enforcer.LockForUpdate()
+	defer unlock()
+	for _, group := range groups {
+		if _, err := e.AddGroupingPolicy(username, group); err != nil {
+			return fmt.Errorf("error restoring group %s", group)
+		}
+	}
+
+	if err := e.SavePolicy(); err != nil {
+		return fmt.Errorf("error saving policy")
+	}
+	return nil
+}
+
+// ListUsers lists users with pagination, search and group, status or deleted filters
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(query.Get("page_size"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	filter := models.UserFilter{
+		Search: query.Get("q"),
+		Status: query.Get("status"),
+		Offset: (page - 1) * pageSize,
+		Limit:  pageSize,
+	}
+
+	switch query.Get("deleted") {
+	case "", "false":
+	case "true":
+		filter.OnlyDeleted = true
+	case "all":
+		filter.IncludeDeleted = true
+	default:
+		http.Error(w, "deleted must be true, false or all", http.StatusBadRequest)
 		return
 	}
 
-	err = database.SoftDeleteUser(username)
+	if group := query.Get("group"); group != "" {
+		members, err := enforcer.GetEnforcer().GetImplicitUsersForRole(group)
+		if err != nil {
+			http.Error(w, "Error getting group users", http.StatusInternalServerError)
+			return
+		}
+		filter.Usernames = append([]string{}, members...)
+	}
+
+	users, total, err := database.ListUsers(filter)
 	if err != nil {
-		http.Error(w, "Error deleting user", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	response := models.UserListResponse{
+		Users:    make([]models.UserResponse, 0, len(users)),
+		Page:     page,
+		PageSize: pageSize,
+		Total:    total,
+	}
+	for _, user := range users {
+		userResponse, err := toUserResponse(user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Users = append(response.Users, *userResponse)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpdateUser changes profile fields and the suspended status of a user
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars["username"]
+
+	var req models.UserUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Email != nil && *req.Email != "" && !strings.Contains(*req.Email, "@") {
+		http.Error(w, "Invalid email", http.StatusBadRequest)
+		return
+	}
+	if req.FullName != nil && len(*req.FullName) > 128 {
+		http.Error(w, "Full name must be at most 128 characters", http.StatusBadRequest)
+		return
+	}
+	if req.Status != nil && *req.Status != models.UserStatusActive && *req.Status != models.UserStatusSuspended {
+		http.Error(w, "Status must be active or suspended", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := database.GetUserByUsername(username); err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !requireSubjectScope(w, r, username) {
+		return
+	}
+
+	err := database.UpdateUserProfile(username, req)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := getUserInfo(username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// RestoreUser undoes SoftDeleteUser and restores the user's group memberships
+func RestoreUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars["username"]
+
+	if _, err := database.GetUserByUsername(username); err == nil {
+		http.Error(w, "User is not deleted", http.StatusConflict)
+		return
+	}
+
+	// The user has no groups until restored, so the scope is that of the
+	// groups it gets back
+	groups, err := database.GetDeletedGroups(username)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Deleted user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return
+	}
+	allowed, err := enforcer.CanManageGroups(middlewares.RequestEnvironment(r), claims.Username, groups)
+	if err != nil {
+		http.Error(w, "Authorization error", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, username+" is outside your administration scope", http.StatusForbidden)
+		return
+	}
+
+	err = restoreUser(username)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Deleted user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := getUserInfo(username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
 }
 
 func GetUserGroups(w http.ResponseWriter, r *http.Request) {
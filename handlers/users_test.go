@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"casbin-demo/enforcer"
+	"casbin-demo/middlewares"
+	"casbin-demo/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// asUser sends a request to a handler as if username had authenticated
+func asUser(handler http.HandlerFunc, username, method, target string, vars map[string]string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), middlewares.ClaimsKey, &models.Claims{Username: username}))
+	rec := httptest.NewRecorder()
+	handler(rec, mux.SetURLVars(req, vars))
+	return rec
+}
+
+func TestUpdateUserOutsideScope(t *testing.T) {
+	useTestPolicy(t)
+	mock := mockDB(t)
+
+	mock.ExpectQuery("FROM users WHERE username=\\$1 AND deleted_at IS NULL").
+		WithArgs("rootuser").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(1, "rootuser", "hash", "", "", "active", nil))
+
+	rec := asUser(UpdateUser, "toanmanager", "PATCH", "/users/rootuser", map[string]string{"username": "rootuser"}, `{"status":"suspended"}`)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("suspending rootuser returned %d, want 403: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUpdateUserInScope(t *testing.T) {
+	useTestPolicy(t)
+	mock := mockDB(t)
+
+	row := func() *sqlmock.Rows {
+		return sqlmock.NewRows(userRowColumns).AddRow(2, "toanpham", "hash", "", "", "suspended", nil)
+	}
+	mock.ExpectQuery("FROM users WHERE username=\\$1").WithArgs("toanpham").WillReturnRows(row())
+	mock.ExpectExec("UPDATE users SET").
+		WithArgs("suspended", "toanpham").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM users WHERE username=\\$1").WithArgs("toanpham").WillReturnRows(row())
+
+	rec := asUser(UpdateUser, "toanmanager", "PATCH", "/users/toanpham", map[string]string{"username": "toanpham"}, `{"status":"suspended"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("suspending toanpham returned %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRestoreUserOutsideScope(t *testing.T) {
+	useTestPolicy(t)
+	mock := mockDB(t)
+
+	mock.ExpectQuery("FROM users WHERE username=\\$1 AND deleted_at IS NULL").
+		WithArgs("formerroot").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT deleted_groups FROM users").
+		WithArgs("formerroot").
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_groups"}).AddRow(`["root"]`))
+
+	rec := asUser(RestoreUser, "toanmanager", "POST", "/users/formerroot/restore", map[string]string{"username": "formerroot"}, "")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("restoring a root member returned %d, want 403: %s", rec.Code, rec.Body)
+	}
+	if ok, _ := enforcer.GetEnforcer().HasGroupingPolicy("formerroot", enforcer.RootRole); ok {
+		t.Error("formerroot was put back in root")
+	}
+}
+
+func TestRestoreUserInScope(t *testing.T) {
+	useTestPolicy(t)
+	mock := mockDB(t)
+
+	mock.ExpectQuery("FROM users WHERE username=\\$1 AND deleted_at IS NULL").
+		WithArgs("formerstaff").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT deleted_groups FROM users").
+		WithArgs("formerstaff").
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_groups"}).AddRow(`["staff"]`))
+	mock.ExpectQuery("UPDATE users u").
+		WithArgs("formerstaff").
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_groups"}).AddRow(`["staff"]`))
+	mock.ExpectQuery("FROM users WHERE username=\\$1 AND deleted_at IS NULL").
+		WithArgs("formerstaff").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(9, "formerstaff", "hash", "", "", "active", nil))
+
+	rec := asUser(RestoreUser, "toanmanager", "POST", "/users/formerstaff/restore", map[string]string{"username": "formerstaff"}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restoring a staff member returned %d: %s", rec.Code, rec.Body)
+	}
+	if ok, _ := enforcer.GetEnforcer().HasGroupingPolicy("formerstaff", "staff"); !ok {
+		t.Error("formerstaff was not put back in staff")
+	}
+}
+
+func TestNewAccountsCannotTakePolicyNames(t *testing.T) {
+	useTestPolicy(t)
+	mock := mockDB(t)
+
+	// root is a role with no users row; leader is a role; toanpham is a group member
+	for _, name := range []string{"root", "leader", "toanpham"} {
+		body := `{"username":"` + name + `","password":"secret123"}`
+		if rec := asUser(RegisterHandler, "toanmanager", "POST", "/users", nil, body); rec.Code != http.StatusConflict {
+			t.Errorf("creating user %s returned %d, want 409", name, rec.Code)
+		}
+		if rec := asUser(RedeemInvitation, "", "POST", "/invitations/token/redeem", map[string]string{"token": "token"}, body); rec.Code != http.StatusConflict {
+			t.Errorf("redeeming as %s returned %d, want 409", name, rec.Code)
+		}
+	}
+
+	mock.ExpectQuery("FROM users WHERE username=\\$1").WithArgs("root").WillReturnError(sql.ErrNoRows)
+	req := httptest.NewRequest("POST", "/scim/v2/Users", strings.NewReader(`{"userName":"root"}`))
+	rec := httptest.NewRecorder()
+	SCIMCreateUser(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("SCIM provisioning root returned %d, want 409: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"casbin-demo/database"
+	"casbin-demo/middlewares"
+	"casbin-demo/models"
+
+	"github.com/gorilla/mux"
+)
+
+var warehouseCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9-]{0,19}$`)
+
+// useWarehouse resolves the warehouse a request works in, 0 meaning the
+// default warehouse, and checks the user may work there. It answers the
+// request itself when either fails.
+func useWarehouse(w http.ResponseWriter, r *http.Request, warehouseID int) (models.Warehouse, bool) {
+	warehouse, err := database.GetWarehouse(warehouseID)
+	if err == database.ErrWarehouseNotFound {
+		http.Error(w, fmt.Sprintf("Warehouse %d not found", warehouseID), http.StatusBadRequest)
+		return warehouse, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return warehouse, false
+	}
+	return warehouse, authorizeWarehouse(w, r, warehouse)
+}
+
+// authorizeWarehouse answers the request with 403 unless the user may
+// exercise the route's permission in the warehouse
+func authorizeWarehouse(w http.ResponseWriter, r *http.Request, warehouse models.Warehouse) bool {
+	allowed, err := middlewares.AuthorizeWarehouse(r, warehouse.Code)
+	if err != nil {
+		http.Error(w, "Authorization error", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("Permission denied in warehouse %s", warehouse.Code), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ListWarehouses returns every warehouse
+func ListWarehouses(w http.ResponseWriter, r *http.Request) {
+	warehouses, err := database.ListWarehouses()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(warehouses)
+}
+
+// CreateWarehouse adds a warehouse. Nobody can work in it until the policy
+// grants its warehouse:<code> scope.
+func CreateWarehouse(w http.ResponseWriter, r *http.Request) {
+	var req models.WarehouseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+	req.Name = strings.TrimSpace(req.Name)
+	if !warehouseCodePattern.MatchString(req.Code) {
+		http.Error(w, "Warehouse code must be 1 to 20 letters, digits or dashes", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Warehouse name must be 1 to 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	warehouse, err := database.CreateWarehouse(req)
+	if err == database.ErrDuplicateWarehouse {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(warehouse)
+}
+
+// GetProductStock returns a product's stock in each warehouse
+func GetProductStock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["productId"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	balances, err := database.GetProductStock(productID)
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+
+	response := models.ProductStockResponse{ProductID: productID, Balances: balances}
+	for _, balance := range balances {
+		response.Quantity += balance.Quantity
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// TransferStock moves stock of a product between two warehouses. The user
+// must be allowed to transfer in both of them.
+func TransferStock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["productId"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Quantity <= 0 {
+		http.Error(w, "Quantity must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.FromWarehouseID == 0 || req.ToWarehouseID == 0 {
+		http.Error(w, "from_warehouse_id and to_warehouse_id are required", http.StatusBadRequest)
+		return
+	}
+	if req.FromWarehouseID == req.ToWarehouseID {
+		http.Error(w, "Cannot transfer stock to the warehouse it is in", http.StatusBadRequest)
+		return
+	}
+
+	stock := models.StockRequest{Quantity: req.Quantity, Unit: req.Unit}
+	if !convertStockUnit(w, productID, &stock) {
+		return
+	}
+	req.Quantity = stock.Quantity
+
+	if _, ok := useWarehouse(w, r, req.FromWarehouseID); !ok {
+		return
+	}
+	if _, ok := useWarehouse(w, r, req.ToWarehouseID); !ok {
+		return
+	}
+
+	claims, ok := r.Context().Value(middlewares.ClaimsKey).(*models.Claims)
+	if !ok {
+		http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+		return
+	}
+
+	op := models.Operation{
+		ProductID: productID,
+		UserID:    claims.UserID,
+		ActorID:   claims.ActorID,
+	}
+
+	transfer, err := database.TransferStock(op, req)
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(transfer)
+}
+
+// GetWarehouseReport totals the stock in each warehouse the user may report on
+func GetWarehouseReport(w http.ResponseWriter, r *http.Request) {
+	summaries, err := database.GetWarehouseSummaries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	visible := []models.WarehouseSummary{}
+	for _, summary := range summaries {
+		allowed, err := middlewares.AuthorizeWarehouse(r, summary.Warehouse.Code)
+		if err != nil {
+			http.Error(w, "Authorization error", http.StatusInternalServerError)
+			return
+		}
+		if allowed {
+			visible = append(visible, summary)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visible)
+}
+
+// GetWarehouseStockReport lists the products in stock in one warehouse with their value
+func GetWarehouseStockReport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	warehouseID, err := strconv.Atoi(vars["warehouseId"])
+	if err != nil || warehouseID <= 0 {
+		http.Error(w, "Invalid warehouse ID", http.StatusBadRequest)
+		return
+	}
+
+	warehouse, err := database.GetWarehouse(warehouseID)
+	if err == database.ErrWarehouseNotFound {
+		http.Error(w, "Warehouse not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !authorizeWarehouse(w, r, warehouse) {
+		return
+	}
+
+	report, err := database.GetWarehouseStock(warehouse)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,27 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"casbin-demo/database"
+)
+
+// PurgeIdempotencyKeys returns a job that forgets idempotency keys older than
+// ttl. A zero ttl keeps keys forever.
+func PurgeIdempotencyKeys(ttl time.Duration) func() error {
+	return func() error {
+		if ttl <= 0 {
+			return nil
+		}
+		purged, err := database.PurgeIdempotencyKeys(time.Now().Add(-ttl))
+		if err != nil {
+			return err
+		}
+
+		if purged > 0 {
+			fmt.Println("Purged", purged, "expired idempotency keys")
+		}
+		return nil
+	}
+}
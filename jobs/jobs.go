@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Every runs job once per interval in the background, logging failures.
+// A zero or negative interval disables the job.
+func Every(name string, interval time.Duration, job func() error) {
+	if interval <= 0 {
+		fmt.Println("Job", name, "disabled")
+		return
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			if err := job(); err != nil {
+				fmt.Println("Job", name, "failed:", err)
+			}
+		}
+	}()
+	fmt.Println("Job", name, "scheduled every", interval)
+}
+
+// Interval reads a duration from value, falling back to defaultInterval when
+// value is empty or invalid. "0" disables a job.
+func Interval(value string, defaultInterval time.Duration) time.Duration {
+	if value == "" {
+		return defaultInterval
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Println("Invalid job interval", value, ", using", defaultInterval)
+		return defaultInterval
+	}
+	return interval
+}
+
+// Days reads a whole number of days from value, falling back to defaultDays
+// when value is empty or invalid. "0" disables a job.
+func Days(value string, defaultDays int) time.Duration {
+	days := defaultDays
+	if value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			fmt.Println("Invalid number of days", value, ", using", defaultDays)
+		} else {
+			days = parsed
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
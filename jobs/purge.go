@@ -0,0 +1,23 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"casbin-demo/database"
+)
+
+// PurgeDeletedProducts returns a job that anonymizes products deleted more than retention ago
+func PurgeDeletedProducts(retention time.Duration) func() error {
+	return func() error {
+		purged, err := database.PurgeDeletedProducts(time.Now().Add(-retention))
+		if err != nil {
+			return err
+		}
+
+		if purged > 0 {
+			fmt.Println("Purged", purged, "deleted products")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package jobs
+
+import (
+	"fmt"
+
+	"casbin-demo/database"
+)
+
+// ReconcileStock reports every product or warehouse balance that differs from its ledger
+func ReconcileStock() error {
+	drifts, err := database.ReconcileStock()
+	if err != nil {
+		return err
+	}
+
+	for _, drift := range drifts {
+		location := ""
+		if drift.WarehouseID != nil {
+			location = fmt.Sprintf(" in warehouse %d", *drift.WarehouseID)
+		}
+		fmt.Printf("Stock drift: product %d (%s) has quantity %d%s but the ledger sums to %d (drift %+d)\n",
+			drift.ProductID, drift.ProductName, drift.Quantity, location, drift.LedgerTotal, drift.Drift)
+	}
+	if len(drifts) > 0 {
+		return fmt.Errorf("%d stock balances drifted from the stock ledger", len(drifts))
+	}
+	return nil
+}
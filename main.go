@@ -4,10 +4,20 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"casbin-demo/commands"
 	"casbin-demo/database"
 	"casbin-demo/handlers"
+	"casbin-demo/jobs"
 	"casbin-demo/middlewares"
+	"casbin-demo/oidc"
+	"casbin-demo/policy"
+	"casbin-demo/ratelimit"
+	"casbin-demo/routes"
 
 	"casbin-demo/enforcer"
 
@@ -15,6 +25,15 @@ import (
 )
 
 func main() {
+	// Subcommands share the route registry with the server
+	if len(os.Args) > 1 {
+		newRouter()
+		if err := commands.Run(os.Args[1:], routes.GetRegistry()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	err := database.InitializeDatabase()
 	if err != nil {
 		log.Fatal(err)
@@ -25,50 +44,186 @@ func main() {
 		log.Fatal(err)
 	}
 
+	err = ratelimit.InitializeLimiter(func(user string) ([]string, error) {
+		return enforcer.GetEnforcer().GetImplicitRolesForUser(user)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = oidc.InitializeProvider()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	router := newRouter()
+
+	err = checkPolicyCoverage(os.Getenv("POLICY_STRICT") == "true")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	watchPolicy()
+
+	jobs.Every("stock reconciliation", jobs.Interval(os.Getenv("STOCK_RECONCILE_INTERVAL"), time.Hour), jobs.ReconcileStock)
+	jobs.Every("inventory snapshot", jobs.Interval(os.Getenv("INVENTORY_SNAPSHOT_INTERVAL"), 24*time.Hour), jobs.SnapshotInventory)
+	jobs.Every("idempotency key purge", time.Hour, jobs.PurgeIdempotencyKeys(middlewares.IdempotencyKeyTTL()))
+
+	// Deleted products are purged daily once PRODUCT_RETENTION_DAYS have passed; 0 keeps them forever
+	retention := jobs.Days(os.Getenv("PRODUCT_RETENTION_DAYS"), 90)
+	jobs.Every("product purge", min(retention, 24*time.Hour), jobs.PurgeDeletedProducts(retention))
+
+	fmt.Println("Server started on port 8080")
+
+	log.Fatal(http.ListenAndServe(":8080", router))
+}
+
+// watchPolicy reloads the enforcer on SIGHUP and, with POLICY_WATCH=true,
+// whenever the model or policy file changes
+func watchPolicy() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := enforcer.Reload(); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
+
+	if os.Getenv("POLICY_WATCH") != "true" {
+		return
+	}
+
+	interval := 5 * time.Second
+	if value, err := time.ParseDuration(os.Getenv("POLICY_WATCH_INTERVAL")); err == nil && value > 0 {
+		interval = value
+	}
+	go enforcer.Watch(interval)
+}
+
+// checkPolicyCoverage reports routes and rules that do not line up.
+// In strict mode any finding stops the server from starting.
+func checkPolicyCoverage(strict bool) error {
+	report, err := policy.Coverage(routes.GetRegistry())
+	if err != nil {
+		return err
+	}
+
+	for _, finding := range report.Findings() {
+		fmt.Println("Policy coverage:", finding)
+	}
+
+	if strict && !report.OK() {
+		return fmt.Errorf("policy coverage check failed with %d findings", len(report.Findings()))
+	}
+	return nil
+}
+
+// newRouter declares every route. Protected routes are registered with the
+// named permission that Authorize enforces for them.
+func newRouter() *mux.Router {
 	router := mux.NewRouter()
+	reg := routes.GetRegistry()
 
 	// Public route
 	router.HandleFunc("/login", handlers.LoginHandler).Methods("POST")
+	router.HandleFunc("/auth/oidc/login", handlers.OIDCLoginHandler).Methods("GET")
+	router.HandleFunc("/auth/oidc/callback", handlers.OIDCCallbackHandler).Methods("GET")
+	router.HandleFunc("/invitations/{token}/redeem", handlers.RedeemInvitation).Methods("POST")
 	// router.HandleFunc("/users", handlers.RegisterHandler).Methods("POST")
 
+	// SCIM provisioning, authenticated with its own bearer credential
+	scim := router.PathPrefix("/scim/v2").Subrouter()
+	scim.Use(middlewares.SCIMAuth())
+	scim.HandleFunc("/Users", handlers.SCIMListUsers).Methods("GET")
+	scim.HandleFunc("/Users", handlers.SCIMCreateUser).Methods("POST")
+	scim.HandleFunc("/Users/{id}", handlers.SCIMGetUser).Methods("GET")
+	scim.HandleFunc("/Users/{id}", handlers.SCIMReplaceUser).Methods("PUT")
+	scim.HandleFunc("/Users/{id}", handlers.SCIMPatchUser).Methods("PATCH")
+	scim.HandleFunc("/Users/{id}", handlers.SCIMDeleteUser).Methods("DELETE")
+	scim.HandleFunc("/Groups", handlers.SCIMListGroups).Methods("GET")
+	scim.HandleFunc("/Groups", handlers.SCIMCreateGroup).Methods("POST")
+	scim.HandleFunc("/Groups/{id}", handlers.SCIMGetGroup).Methods("GET")
+	scim.HandleFunc("/Groups/{id}", handlers.SCIMReplaceGroup).Methods("PUT")
+	scim.HandleFunc("/Groups/{id}", handlers.SCIMPatchGroup).Methods("PATCH")
+	scim.HandleFunc("/Groups/{id}", handlers.SCIMDeleteGroup).Methods("DELETE")
+
 	protected := router.PathPrefix("").Subrouter()
 	protected.Use(middlewares.Authenticate())
-	protected.Use(middlewares.Authorize(enforcer.GetEnforcer()))
+	protected.Use(middlewares.Authorize(reg))
+	protected.Use(middlewares.RateLimit(reg))
 
 	// Users management
-	protected.HandleFunc("/users/me", handlers.GetCurrentUserInfo).Methods("GET")
-	protected.HandleFunc("/users/{username}", handlers.GetUserByUsername).Methods("GET")
-	protected.HandleFunc("/users/{username}", handlers.SoftDeleteUser).Methods("DELETE")
-	protected.HandleFunc("/users", handlers.RegisterHandler).Methods("POST")
-	protected.HandleFunc("/users/{username}/groups", handlers.GetUserGroups).Methods("GET")
-
-	// Products management
-	protected.HandleFunc("/products", handlers.GetAllProducts).Methods("GET")
-	protected.HandleFunc("/products", handlers.CreateProduct).Methods("POST")
-	protected.HandleFunc("/products/{productId}", handlers.UpdateProduct).Methods("PATCH")
-	protected.HandleFunc("/products/{productId}", handlers.GetProductByID).Methods("GET")
-	protected.HandleFunc("/products/{productId}", handlers.DeleteProduct).Methods("DELETE")
-	protected.HandleFunc("/products/{productId}/stocks/in", handlers.AddStock).Methods("PATCH")
-	protected.HandleFunc("/products/{productId}/stocks/out", handlers.RemoveStock).Methods("PATCH")
+	reg.Handle(protected, "GET", "/users/me", "users:me", handlers.GetCurrentUserInfo)
+	reg.Handle(protected, "GET", "/users/{username}", "users:read", handlers.GetUserByUsername)
+	reg.Handle(protected, "DELETE", "/users/{username}", "users:delete", handlers.SoftDeleteUser)
+	reg.Handle(protected, "PATCH", "/users/{username}", "users:update", handlers.UpdateUser)
+	reg.Handle(protected, "POST", "/users/{username}/restore", "users:restore", handlers.RestoreUser)
+	reg.Handle(protected, "GET", "/users", "users:list", handlers.ListUsers)
+	reg.Handle(protected, "POST", "/users", "users:create", handlers.RegisterHandler)
+	reg.Handle(protected, "GET", "/users/{username}/groups", "users:groups", handlers.GetUserGroups)
+	reg.Handle(protected, "POST", "/users/{username}/impersonate", "users:impersonate", handlers.ImpersonateUser)
+
+	// Invitations
+	reg.Handle(protected, "POST", "/invitations", "invitations:create", handlers.CreateInvitation)
+
+	// Products management. Writes accept an Idempotency-Key so retries are safe.
+	reg.Handle(protected, "GET", "/products", "products:list", handlers.GetAllProducts)
+	reg.Handle(protected, "GET", "/products/trash", "products:trash", handlers.GetProductTrash)
+	reg.Handle(protected, "POST", "/products", "products:create", middlewares.Idempotent(handlers.CreateProduct))
+	reg.Handle(protected, "PATCH", "/products/{productId}", "products:update", middlewares.Idempotent(handlers.UpdateProduct))
+	reg.Handle(protected, "GET", "/products/{productId}", "products:read", handlers.GetProductByID)
+	reg.Handle(protected, "DELETE", "/products/{productId}", "products:delete", middlewares.Idempotent(handlers.DeleteProduct))
+	reg.Handle(protected, "PATCH", "/products/{productId}/stocks/in", "stock:in", middlewares.Idempotent(handlers.AddStock))
+	reg.Handle(protected, "PATCH", "/products/{productId}/stocks/out", "stock:out", middlewares.Idempotent(handlers.RemoveStock))
+	reg.Handle(protected, "GET", "/products/{productId}/stock", "products:read", handlers.GetProductStock)
+	reg.Handle(protected, "POST", "/products/{productId}/transfers", "stock:transfer", middlewares.Idempotent(handlers.TransferStock))
+	reg.Handle(protected, "POST", "/products/{productId}/bin-moves", "stock:bin-move", middlewares.Idempotent(handlers.MoveBinStock))
+	reg.Handle(protected, "GET", "/products/{productId}/history", "products:history", handlers.GetProductHistory)
+	reg.Handle(protected, "GET", "/products/by-barcode/{barcode}", "products:read", handlers.GetProductByBarcode)
+	reg.Handle(protected, "GET", "/products/{productId}/changes", "products:changes", handlers.GetProductChanges)
+	reg.Handle(protected, "POST", "/products/{productId}/restore", "products:restore", middlewares.Idempotent(handlers.RestoreProduct))
+	reg.Handle(protected, "POST", "/products/{productId}/changes/{operationId}/revert", "products:revert", middlewares.Idempotent(handlers.RevertProductChange))
+
+	// Product categories
+	reg.Handle(protected, "GET", "/categories", "categories:list", handlers.ListCategories)
+	reg.Handle(protected, "POST", "/categories", "categories:create", handlers.CreateCategory)
+
+	// Warehouses. Stock work is further limited to warehouse:<code> scopes in the policy.
+	reg.Handle(protected, "GET", "/warehouses", "warehouses:list", handlers.ListWarehouses)
+	reg.Handle(protected, "POST", "/warehouses", "warehouses:create", handlers.CreateWarehouse)
+	reg.Handle(protected, "GET", "/warehouses/{warehouseId}/bins", "bins:list", handlers.ListBins)
+	reg.Handle(protected, "POST", "/warehouses/{warehouseId}/bins", "bins:create", handlers.CreateBin)
+	reg.Handle(protected, "POST", "/warehouses/{warehouseId}/pick-lists", "pick-lists:create", handlers.CreatePickList)
 
 	// Report
-	protected.HandleFunc("/reports/products", handlers.GetProductsReport).Methods("GET")
+	reg.Handle(protected, "GET", "/reports/products", "reports:products", handlers.GetProductsReport)
+	reg.Handle(protected, "GET", "/reports/stock-reconciliation", "reports:reconciliation", handlers.GetStockReconciliation)
+	reg.Handle(protected, "GET", "/reports/inventory", "reports:inventory", handlers.GetInventoryReport)
+	reg.Handle(protected, "GET", "/reports/warehouses", "reports:warehouses", handlers.GetWarehouseReport)
+	reg.Handle(protected, "GET", "/reports/warehouses/{warehouseId}", "reports:warehouses", handlers.GetWarehouseStockReport)
 
 	// Group management
-	protected.HandleFunc("/groups/{groupname}/users/{username}", handlers.AddUserToGroup).Methods("POST")
-	protected.HandleFunc("/groups/{groupname}/users/{username}", handlers.RemoveUserFromGroup).Methods("DELETE")
-	protected.HandleFunc("/groups/{groupname}/users", handlers.GetGroupUsers).Methods("GET")
-	protected.HandleFunc("/groups/{groupname}", handlers.DeleteGroup).Methods("DELETE")
+	reg.Handle(protected, "POST", "/groups/{groupname}/users/{username}", "groups:add-member", handlers.AddUserToGroup)
+	reg.Handle(protected, "DELETE", "/groups/{groupname}/users/{username}", "groups:remove-member", handlers.RemoveUserFromGroup)
+	reg.Handle(protected, "GET", "/groups/{groupname}/users", "groups:members", handlers.GetGroupUsers)
+	reg.Handle(protected, "DELETE", "/groups/{groupname}", "groups:delete", handlers.DeleteGroup)
 
 	// Permissions management
-	protected.HandleFunc("/permissions/{name}", handlers.DeletePermissions).Methods("DELETE")
-	protected.HandleFunc("/permissions", handlers.GrantPermission).Methods("POST")
-
-	fmt.Println("Server started on port 8080")
-
-	log.Fatal(http.ListenAndServe(":8080", router))
+	reg.Handle(protected, "DELETE", "/permissions/rules", "permissions:revoke", handlers.RevokePermission)
+	reg.Handle(protected, "PUT", "/permissions/rules", "permissions:update", handlers.UpdatePermission)
+	reg.Handle(protected, "DELETE", "/permissions/rules/{id}", "permissions:revoke", handlers.RevokePermissionByID)
+	reg.Handle(protected, "PUT", "/permissions/rules/{id}", "permissions:update", handlers.UpdatePermissionByID)
+	reg.Handle(protected, "DELETE", "/permissions/{name}", "permissions:delete-all", handlers.DeletePermissions)
+	reg.Handle(protected, "GET", "/permissions", "permissions:list", handlers.ListPermissions)
+	reg.Handle(protected, "POST", "/permissions", "permissions:grant", handlers.GrantPermission)
+
+	// Administration
+	reg.Handle(protected, "GET", "/admin/policy/coverage", "admin:policy-coverage", handlers.GetPolicyCoverage)
+	reg.Handle(protected, "GET", "/admin/access-matrix", "admin:access-matrix", handlers.GetAccessMatrix)
+	reg.Handle(protected, "POST", "/admin/policy/reload", "admin:policy-reload", handlers.ReloadPolicy)
+	reg.Handle(protected, "GET", "/admin/impersonations", "admin:impersonations", handlers.ListImpersonations)
+
+	return router
 }
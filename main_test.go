@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"casbin-demo/policy"
+	"casbin-demo/routes"
+)
+
+// TestPolicySuite runs the policy test suite, so a policy change that breaks
+// an expected decision fails go test as well as the policy-test command
+func TestPolicySuite(t *testing.T) {
+	newRouter()
+
+	result, err := policy.RunSuiteFile("config/policy_tests.json", routes.GetRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Cases) == 0 {
+		t.Fatal("the suite has no cases")
+	}
+	for _, c := range result.Failures() {
+		t.Errorf("%s: %s", c.Case.Name, c.Explanation)
+	}
+}
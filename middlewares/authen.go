@@ -2,12 +2,14 @@ package middlewares
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"os"
 	"strings"
 
 	"net/http"
 
+	"casbin-demo/database"
 	"casbin-demo/models"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -59,8 +61,42 @@ func Authenticate() mux.MiddlewareFunc {
 				return
 			}
 
+			// Tokens outlive suspension and deletion, so the account is checked on
+			// every request; an impersonation token also needs its actor active
+			if ok, err := activeUser(claims.UserID, claims.Username); err != nil || !ok {
+				writeInactiveUser(w, err)
+				return
+			}
+			if claims.Impersonated() {
+				if ok, err := activeUser(claims.ActorID, claims.ActorUsername); err != nil || !ok {
+					writeInactiveUser(w, err)
+					return
+				}
+			}
+
 			ctx := context.WithValue(r.Context(), ClaimsKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// activeUser reports whether the user a token was issued to still exists
+// under the same name and is not suspended
+func activeUser(userID int, username string) (bool, error) {
+	user, err := database.GetUserByID(userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.Username == username && user.DeletedAt == nil && user.Status != models.UserStatusSuspended, nil
+}
+
+func writeInactiveUser(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, "Error checking account status", http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, "Unauthorized: Account is suspended or deleted", http.StatusUnauthorized)
+}
@@ -2,14 +2,21 @@ package middlewares
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
+	"casbin-demo/enforcer"
 	"casbin-demo/models"
-
-	"github.com/casbin/casbin/v2"
+	"casbin-demo/routes"
 )
 
-func Authorize(e *casbin.Enforcer) func(http.Handler) http.Handler {
+// Authorize enforces the named permission registered for the matched route,
+// so policies do not depend on URL paths. The enforcer is looked up on every
+// request so a reloaded policy takes effect immediately.
+func Authorize(reg *routes.Registry) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Get username from JWT context
@@ -18,9 +25,16 @@ func Authorize(e *casbin.Enforcer) func(http.Handler) http.Handler {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
-			fmt.Println("username", claims.Username, ", path", r.URL.Path, ", method", r.Method)
 
-			ok, err := e.Enforce(claims.Username, r.URL.Path, r.Method)
+			// Routes without a declared permission are never reachable
+			permission, ok := reg.Permission(r)
+			if !ok {
+				http.Error(w, "Permission denied", http.StatusForbidden)
+				return
+			}
+			fmt.Println("username", claims.Username, ", actor", claims.Actor(), ", permission", permission, ", path", r.URL.Path, ", method", r.Method, ", ip", ClientIP(r))
+
+			ok, err := enforcer.GetEnforcer().EnforceIn(RequestEnvironment(r), claims.Username, permission, r.Method)
 			if err != nil {
 				http.Error(w, "Authorization error", http.StatusInternalServerError)
 				return
@@ -35,3 +49,42 @@ func Authorize(e *casbin.Enforcer) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// AuthorizeWarehouse checks that the user may exercise the matched route's
+// permission in a warehouse. Handlers call it once they know which
+// warehouses a request touches, after Authorize has let the request through.
+func AuthorizeWarehouse(r *http.Request, warehouseCode string) (bool, error) {
+	claims, ok := r.Context().Value(ClaimsKey).(*models.Claims)
+	if !ok {
+		return false, nil
+	}
+
+	permission, ok := routes.GetRegistry().Permission(r)
+	if !ok {
+		return false, nil
+	}
+	return enforcer.CanUseWarehouse(RequestEnvironment(r), claims.Username, warehouseCode, permission)
+}
+
+// RequestEnvironment is the environment conditional rules are checked
+// against for a request, for route permissions and scope checks alike
+func RequestEnvironment(r *http.Request) enforcer.Environment {
+	return enforcer.Environment{IP: ClientIP(r), Time: time.Now()}
+}
+
+// ClientIP returns the address the request came from. X-Forwarded-For is only
+// trusted when TRUST_PROXY_HEADERS=true, i.e. behind a reverse proxy.
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,173 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"casbin-demo/database"
+	"casbin-demo/models"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKey    = 255
+	maxIdempotentBody    = 1 << 20
+
+	// DefaultIdempotencyKeyTTL is used when IDEMPOTENCY_KEY_TTL is not set
+	DefaultIdempotencyKeyTTL = 24 * time.Hour
+)
+
+// IdempotencyKeyTTL returns how long a key is remembered, from
+// IDEMPOTENCY_KEY_TTL if set. Zero keeps keys forever.
+func IdempotencyKeyTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_TTL")); err == nil && ttl >= 0 {
+		return ttl
+	}
+	return DefaultIdempotencyKeyTTL
+}
+
+// idempotencyRecorder passes a response through while keeping a copy to store
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+// hashRequest identifies a request by everything that decides its outcome
+func hashRequest(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n%s\n", r.Method, r.URL.RequestURI(), r.Header.Get("If-Match"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Idempotent lets clients retry a write safely. The first request with an
+// Idempotency-Key runs the handler and stores its response, including the
+// Content-Type, ETag and Location headers; later requests by the same user
+// with that key get the stored response replayed. Reusing a key for a
+// different request is rejected, as is a retry that arrives while the first
+// request is still running. Server errors and rate limit
+// rejections are not stored, so those requests can be retried, and neither
+// is anything once the key is older than IdempotencyKeyTTL.
+func Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKey), http.StatusBadRequest)
+			return
+		}
+
+		claims, ok := r.Context().Value(ClaimsKey).(*models.Claims)
+		if !ok {
+			http.Error(w, "Error retrieving user info", http.StatusInternalServerError)
+			return
+		}
+
+		// The hash has to cover the whole body, so oversized bodies are refused
+		// rather than cut short
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			http.Error(w, fmt.Sprintf("Request body must be at most %d bytes", maxIdempotentBody), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		requestHash := hashRequest(r, body)
+
+		var expiredBefore time.Time
+		if ttl := IdempotencyKeyTTL(); ttl > 0 {
+			expiredBefore = time.Now().Add(-ttl)
+		}
+		record, reserved, err := database.ReserveIdempotencyKey(claims.UserID, key, requestHash, expiredBefore)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Idempotency error", http.StatusInternalServerError)
+			return
+		}
+
+		if !reserved {
+			switch {
+			case record.RequestHash != requestHash:
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			case record.StatusCode == 0:
+				http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+			default:
+				for header, value := range map[string]string{
+					"Content-Type": record.ContentType,
+					"ETag":         record.ETag,
+					"Location":     record.Location,
+				} {
+					if value != "" {
+						w.Header().Set(header, value)
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.StatusCode)
+				w.Write(record.Body)
+			}
+			return
+		}
+
+		// A panicking handler must not leave the key reserved forever
+		defer func() {
+			if p := recover(); p != nil {
+				if err := database.ReleaseIdempotencyKey(claims.UserID, key); err != nil {
+					fmt.Println(err)
+				}
+				panic(p)
+			}
+		}()
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		if rec.status >= http.StatusInternalServerError || rec.status == http.StatusTooManyRequests {
+			if err := database.ReleaseIdempotencyKey(claims.UserID, key); err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		record.StatusCode = rec.status
+		record.ContentType = w.Header().Get("Content-Type")
+		record.ETag = w.Header().Get("ETag")
+		record.Location = w.Header().Get("Location")
+		record.Body = rec.body.Bytes()
+		if err := database.CompleteIdempotencyKey(record); err != nil {
+			fmt.Println(err, "for key", strconv.Quote(key))
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package middlewares
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"casbin-demo/database"
+	"casbin-demo/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.SetDB(conn)
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+		database.SetDB(nil)
+	})
+	return mock
+}
+
+func idempotentRequest(body string) *http.Request {
+	r := httptest.NewRequest("PATCH", "/products/1/stocks/in", strings.NewReader(body))
+	r.Header.Set(IdempotencyKeyHeader, "key-1")
+	claims := &models.Claims{Username: "alice", UserID: 7}
+	return r.WithContext(context.WithValue(r.Context(), ClaimsKey, claims))
+}
+
+// cutoff matches an expiry cutoff within a second of want
+type cutoff struct {
+	want time.Time
+}
+
+func (c cutoff) Match(v driver.Value) bool {
+	got, ok := v.(time.Time)
+	return ok && got.Sub(c.want).Abs() < time.Second
+}
+
+func TestIdempotentRejectsOversizedBody(t *testing.T) {
+	mockDB(t)
+
+	called := false
+	handler := Idempotent(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	rec := httptest.NewRecorder()
+	handler(rec, idempotentRequest(`{"quantity": 5, "reason": "`+strings.Repeat("x", maxIdempotentBody)+`"}`))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	if called {
+		t.Error("handler ran for an oversized body")
+	}
+}
+
+func TestIdempotentReleasesKeyOnPanic(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs(7, "key-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM idempotency_keys").
+		WithArgs(7, "key-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := Idempotent(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("recovered %v, want the handler's panic to propagate", p)
+		}
+	}()
+	handler(httptest.NewRecorder(), idempotentRequest(`{"quantity": 5}`))
+}
+
+func TestIdempotentExpiresKeysAfterTTL(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_KEY_TTL", "2h")
+	mock := mockDB(t)
+
+	// The stored key is older than the TTL, so the reservation reclaims it
+	mock.ExpectExec("ON CONFLICT \\(user_id, idempotency_key\\) DO UPDATE .* WHERE idempotency_keys.created_at < \\$4").
+		WithArgs(7, "key-1", sqlmock.AnyArg(), cutoff{time.Now().Add(-2 * time.Hour)}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE idempotency_keys").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	called := false
+	handler := Idempotent(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	rec := httptest.NewRecorder()
+	handler(rec, idempotentRequest(`{"quantity": 5}`))
+	if !called || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("an expired key was replayed instead of running the handler")
+	}
+}
+
+func TestIdempotentReplaysStoredResponse(t *testing.T) {
+	body := `{"quantity": 5}`
+	mock := mockDB(t)
+	mock.ExpectExec("INSERT INTO idempotency_keys").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT request_hash").
+		WithArgs(7, "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "etag", "location", "response_body", "created_at"}).
+			AddRow(hashRequest(idempotentRequest(body), []byte(body)), 201, "application/json", `"1"`, "/products/1", []byte(`{"id":1}`), time.Now()))
+
+	handler := Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler ran for a completed key")
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, idempotentRequest(body))
+	if rec.Code != http.StatusCreated || rec.Body.String() != `{"id":1}` || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay = %d %q", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("ETag") != `"1"` || rec.Header().Get("Location") != "/products/1" {
+		t.Errorf("replay headers = %v, want the stored ETag and Location", rec.Header())
+	}
+}
+
+func TestIdempotentStoresResponseHeaders(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectExec("INSERT INTO idempotency_keys").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE idempotency_keys").
+		WithArgs(http.StatusCreated, "application/json", `"2"`, "/products/1", []byte(`{"id":1}`), 7, "key-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"2"`)
+		w.Header().Set("Location", "/products/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+	})
+	handler(httptest.NewRecorder(), idempotentRequest(`{"quantity": 5}`))
+}
@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"casbin-demo/models"
+	"casbin-demo/ratelimit"
+	"casbin-demo/routes"
+)
+
+// RateLimit counts each request against the per-minute limit of the user's
+// roles for the matched route's permission
+func RateLimit(reg *routes.Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limiter := ratelimit.GetLimiter()
+			claims, ok := r.Context().Value(ClaimsKey).(*models.Claims)
+			permission, found := reg.Permission(r)
+			if limiter == nil || !ok || !found {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.AllowRequest(claims.Username, permission)
+			if err != nil {
+				http.Error(w, "Rate limit error", http.StatusInternalServerError)
+				return
+			}
+			if !result.Allowed {
+				fmt.Println("rate limited", claims.Username, ", permission", permission)
+				TooManyRequests(w, result, "Rate limit exceeded")
+				return
+			}
+			if result.Limit > 0 {
+				w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+				w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TooManyRequests writes a 429 response telling the client when to retry
+func TooManyRequests(w http.ResponseWriter, result ratelimit.Result, message string) {
+	w.Header().Set("Retry-After", strconv.FormatInt(result.RetryAfter(), 10))
+	w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+	w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
@@ -0,0 +1,32 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+// SCIMAuth protects the provisioning endpoints with a dedicated bearer credential,
+// separate from user session tokens. SCIM_TOKEN is read on every request, so it
+// is seen once the .env file is loaded and can be rotated without a restart.
+func SCIMAuth() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scimToken := []byte(os.Getenv("SCIM_TOKEN"))
+			if len(scimToken) == 0 {
+				http.Error(w, "SCIM provisioning is not configured", http.StatusNotFound)
+				return
+			}
+
+			tokenString := extractToken(r)
+			if tokenString == "" || subtle.ConstantTimeCompare([]byte(tokenString), scimToken) != 1 {
+				http.Error(w, "Unauthorized: Invalid SCIM token", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
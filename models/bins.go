@@ -0,0 +1,103 @@
+package models
+
+import "time"
+
+// Bin is a storage location inside a warehouse, addressed by zone, aisle,
+// shelf and bin. Stock in a warehouse that is in no bin is unassigned, e.g.
+// received but not yet put away.
+type Bin struct {
+	ID          int    `json:"id"`
+	WarehouseID int    `json:"warehouse_id"`
+	Zone        string `json:"zone"`
+	Aisle       string `json:"aisle"`
+	Shelf       string `json:"shelf"`
+	Bin         string `json:"bin"`
+	// Code is the full location, e.g. "A-03-2-B"
+	Code string `json:"code"`
+	// Sequence is the bin's position on the warehouse's walking path
+	Sequence int `json:"sequence"`
+}
+
+type BinRequest struct {
+	Zone  string `json:"zone"`
+	Aisle string `json:"aisle"`
+	Shelf string `json:"shelf"`
+	Bin   string `json:"bin"`
+}
+
+// BinStock is a product's quantity in a bin
+type BinStock struct {
+	Bin       Bin    `json:"bin"`
+	ProductID int    `json:"product_id"`
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+}
+
+// BinBalance is the quantity of one product in a bin
+type BinBalance struct {
+	BinID    int    `json:"bin_id"`
+	BinCode  string `json:"bin_code"`
+	Quantity int    `json:"quantity"`
+}
+
+// BinMoveRequest moves stock of a product between two bins of a warehouse.
+// A bin ID of 0 is the warehouse's unassigned stock, so moving from 0 puts
+// stock away and moving to 0 takes it off the shelf.
+type BinMoveRequest struct {
+	FromBinID int    `json:"from_bin_id"`
+	ToBinID   int    `json:"to_bin_id"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+	// Unit is one of the product's purchase units; empty means the base unit
+	Unit string `json:"unit"`
+}
+
+// BinMove is a completed bin move, recorded in the ledger as a BIN_MOVE entry
+type BinMove struct {
+	ID          int       `json:"id"`
+	ProductID   int       `json:"product_id"`
+	WarehouseID int       `json:"warehouse_id"`
+	FromBinID   *int      `json:"from_bin_id"`
+	ToBinID     *int      `json:"to_bin_id"`
+	Quantity    int       `json:"quantity"`
+	Reason      string    `json:"reason"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PickListRequest asks for the bins to pick a set of outgoing quantities from
+type PickListRequest struct {
+	Lines []PickLine `json:"lines"`
+}
+
+type PickLine struct {
+	ProductID int `json:"product_id"`
+	Quantity  int `json:"quantity"`
+	// Unit is one of the product's purchase units; empty means the base unit
+	Unit string `json:"unit"`
+}
+
+// Pick is one stop on a pick list
+type Pick struct {
+	Stop      int    `json:"stop"`
+	BinID     int    `json:"bin_id"`
+	BinCode   string `json:"bin_code"`
+	ProductID int    `json:"product_id"`
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+}
+
+// PickShortage is a product whose shelved stock cannot cover the requested quantity
+type PickShortage struct {
+	ProductID int `json:"product_id"`
+	Requested int `json:"requested"`
+	Allocated int `json:"allocated"`
+}
+
+// PickList lists the picks in walking path order
+type PickList struct {
+	WarehouseID int            `json:"warehouse_id"`
+	Picks       []Pick         `json:"picks"`
+	Shortages   []PickShortage `json:"shortages"`
+}
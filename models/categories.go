@@ -0,0 +1,15 @@
+package models
+
+// Category is a node of the product category tree
+type Category struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"`
+	// Path names the category and its ancestors, e.g. "Food / Dairy / Cheese"
+	Path string `json:"path"`
+}
+
+type CategoryRequest struct {
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is the stored outcome of a write request sent with an
+// Idempotency-Key. StatusCode is 0 while the first request is in progress.
+type IdempotencyRecord struct {
+	UserID      int
+	Key         string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	ETag        string
+	Location    string
+	Body        []byte
+	CreatedAt   time.Time
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type ImpersonationRequest struct {
+	Reason           string `json:"reason"`
+	ExpiresInMinutes int    `json:"expires_in_minutes"`
+}
+
+type ImpersonationResponse struct {
+	Token     string    `json:"token"`
+	Username  string    `json:"username"`
+	Actor     string    `json:"actor"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Impersonation is the audit record of one impersonation token
+type Impersonation struct {
+	ID             int       `json:"id"`
+	ActorID        int       `json:"actor_id"`
+	ActorUsername  string    `json:"actor_username"`
+	TargetID       int       `json:"target_id"`
+	TargetUsername string    `json:"target_username"`
+	Reason         string    `json:"reason"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type InvitationRequest struct {
+	Email          string   `json:"email"`
+	Groups         []string `json:"groups"`
+	ExpiresInHours int      `json:"expires_in_hours"`
+}
+
+type Invitation struct {
+	ID         int        `json:"id"`
+	Token      string     `json:"token,omitempty"`
+	Email      string     `json:"email,omitempty"`
+	Groups     []string   `json:"groups"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedBy  int        `json:"created_by"`
+	RedeemedAt *time.Time `json:"redeemed_at,omitempty"`
+}
+
+type RedeemInvitationRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
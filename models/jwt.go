@@ -5,5 +5,22 @@ import "github.com/golang-jwt/jwt/v4"
 type Claims struct {
 	Username string `json:"username"`
 	UserID   int    `json:"user_id"`
+	// ActorUsername and ActorID identify the real user behind an
+	// impersonation token; they are empty for a user's own token
+	ActorUsername string `json:"actor_username,omitempty"`
+	ActorID       int    `json:"actor_id,omitempty"`
 	jwt.RegisteredClaims
 }
+
+// Impersonated reports whether the token was issued to act as another user
+func (c *Claims) Impersonated() bool {
+	return c.ActorUsername != ""
+}
+
+// Actor returns the user who is really making the request
+func (c *Claims) Actor() string {
+	if c.Impersonated() {
+		return c.ActorUsername
+	}
+	return c.Username
+}
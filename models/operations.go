@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type OperationType string
 
 const (
@@ -8,6 +10,17 @@ const (
 	OperationAdjustProduct OperationType = "ADJUST_PRODUCT"
 	OperationAddProduct    OperationType = "CREATE_PRODUCT"
 	OperationDelProduct    OperationType = "DELETE_PRODUCT"
+	// OperationRestoreProduct reverses a DELETE_PRODUCT
+	OperationRestoreProduct OperationType = "RESTORE_PRODUCT"
+	// OperationPurgeProduct anonymizes a product once it has been deleted for the retention period
+	OperationPurgeProduct OperationType = "PURGE_PRODUCT"
+	// OperationTransferOut and OperationTransferIn are the two halves of a stock transfer
+	OperationTransferOut OperationType = "TRANSFER_OUT"
+	OperationTransferIn  OperationType = "TRANSFER_IN"
+	// OperationBinMove moves stock between bins of a warehouse without changing its balance
+	OperationBinMove OperationType = "BIN_MOVE"
+	// OperationOpeningBalance carries a product's stock into the ledger when it was introduced
+	OperationOpeningBalance OperationType = "OPENING_BALANCE"
 )
 
 type Operation struct {
@@ -18,16 +31,74 @@ type Operation struct {
 	Reason    string
 	Type      OperationType
 	UserID    int
+	// ActorID is the impersonating user, if any
+	ActorID int
+	// UnitCost is the cost per unit of stock added; 0 uses the product's unit price
+	UnitCost float64
+	// Versions are the product versions the client expects (If-Match); empty skips the check
+	Versions []int
+	// WarehouseID is the warehouse a stock change is made in; 0 is the default warehouse
+	WarehouseID int
+	// BinID is the bin stock is put away in or taken from; 0 is none
+	BinID int
 }
 
 // First, add this struct to your models package
 type OperationReport struct {
-	ID                int           `json:"id"`
-	ProductID         int           `json:"product_id"`
-	ProductName       string        `json:"product_name"`
-	Type              OperationType `json:"operation_type"`
-	Reason            string        `json:"reason"`
-	CreatedBy         int           `json:"created_by"`
-	CreatedByUsername string        `json:"created_by_username"`
-	CreatedAt         string        `json:"created_at"`
+	ID                int                    `json:"id"`
+	ProductID         int                    `json:"product_id"`
+	ProductName       string                 `json:"product_name"`
+	Type              OperationType          `json:"operation_type"`
+	Reason            string                 `json:"reason"`
+	CreatedBy         int                    `json:"created_by"`
+	CreatedByUsername string                 `json:"created_by_username"`
+	QuantityDelta     int                    `json:"quantity_delta"`
+	BalanceAfter      *int                   `json:"balance_after"`
+	UnitCost          *float64               `json:"unit_cost"`
+	ActorID           *int                   `json:"actor_id,omitempty"`
+	ActorUsername     *string                `json:"actor_username,omitempty"`
+	ChangedFields     []string               `json:"changed_fields,omitempty"`
+	Changes           map[string]FieldChange `json:"changes,omitempty"`
+	RevertOf          *int                   `json:"revert_of,omitempty"`
+	// WarehouseID is where the stock changed, with the warehouse's balance after it
+	WarehouseID           *int `json:"warehouse_id,omitempty"`
+	WarehouseBalanceAfter *int `json:"warehouse_balance_after,omitempty"`
+	TransferID            *int `json:"transfer_id,omitempty"`
+	// BinMoveID is the bin move a BIN_MOVE entry records
+	BinMoveID     *int   `json:"bin_move_id,omitempty"`
+	FromBinID     *int   `json:"from_bin_id,omitempty"`
+	ToBinID       *int   `json:"to_bin_id,omitempty"`
+	MovedQuantity *int   `json:"moved_quantity,omitempty"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// FieldChange is a product field's value before and after an adjustment
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// ProductChange is an adjustment of a product with its field diff
+type ProductChange struct {
+	OperationID       int                    `json:"operation_id"`
+	ProductID         int                    `json:"product_id"`
+	Reason            string                 `json:"reason"`
+	CreatedBy         int                    `json:"created_by"`
+	CreatedByUsername string                 `json:"created_by_username"`
+	ActorUsername     *string                `json:"actor_username,omitempty"`
+	Changes           map[string]FieldChange `json:"changes"`
+	RevertOf          *int                   `json:"revert_of,omitempty"`
+	CreatedAt         time.Time              `json:"created_at"`
+}
+
+// StockDrift is a product, or a product's balance in a warehouse, whose
+// stored quantity disagrees with its ledger
+type StockDrift struct {
+	ProductID   int    `json:"product_id"`
+	ProductName string `json:"product_name"`
+	WarehouseID *int   `json:"warehouse_id,omitempty"`
+	Quantity    int    `json:"quantity"`
+	LedgerTotal int    `json:"ledger_total"`
+	LastBalance *int   `json:"last_balance"`
+	Drift       int    `json:"drift"`
 }
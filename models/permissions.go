@@ -5,4 +5,36 @@ type PermissionRequest struct {
 	Object  string `json:"object"`
 	Action  string `json:"action"`
 	Effect  string `json:"effect"`
+	// Optional conditions, e.g. "10.0.0.0/8", "07:00-19:00" and "Mon-Fri"
+	CIDR  string `json:"cidr,omitempty"`
+	Hours string `json:"hours,omitempty"`
+	Days  string `json:"days,omitempty"`
+}
+
+// Rule returns the request as a policy tuple
+func (p PermissionRequest) Rule() []string {
+	return []string{p.Subject, p.Object, p.Action, p.Effect, p.CIDR, p.Hours, p.Days}
+}
+
+type PermissionRule struct {
+	ID      string `json:"id"`
+	Subject string `json:"subject"`
+	Object  string `json:"object"`
+	Action  string `json:"action"`
+	Effect  string `json:"effect"`
+	CIDR    string `json:"cidr,omitempty"`
+	Hours   string `json:"hours,omitempty"`
+	Days    string `json:"days,omitempty"`
+}
+
+type PermissionUpdateRequest struct {
+	Old PermissionRequest `json:"old"`
+	New PermissionRequest `json:"new"`
+}
+
+type PermissionListResponse struct {
+	Rules    []PermissionRule `json:"rules"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+	Total    int              `json:"total"`
 }
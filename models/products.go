@@ -1,25 +1,125 @@
 package models
 
+import "time"
+
+// UnitsOfMeasure are the units a product's stock can be counted in
+var UnitsOfMeasure = []string{"each", "pair", "pack", "box", "case", "pallet", "g", "kg", "t", "ml", "l", "cm", "m"}
+
+// DefaultBaseUnit is the base unit of products created without one
+const DefaultBaseUnit = "each"
+
 type ProductRequest struct {
 	Name      string  `json:"name"`
 	UnitPrice float64 `json:"unit_price"`
 	Quantity  int     `json:"quantity"`
 	Reason    string  `json:"reason"`
+	SKU       string  `json:"sku"`
+	// Barcodes are EAN-8, EAN-13 or UPC-A codes
+	Barcodes   []string `json:"barcodes"`
+	CategoryID *int     `json:"category_id"`
+	// BaseUnit is the unit stock is counted in, and PurchaseUnits how many
+	// base units each other unit holds, e.g. {"case": 24}
+	BaseUnit      string                 `json:"base_unit"`
+	PurchaseUnits map[string]float64     `json:"purchase_units"`
+	Attributes    map[string]interface{} `json:"attributes"`
+	// WarehouseID receives the opening quantity; 0 is the default warehouse
+	WarehouseID int `json:"warehouse_id"`
+}
+
+// ProductUpdate holds the fields a product update changes; nil fields are
+// left alone. A CategoryID of 0 removes the product from its category.
+type ProductUpdate struct {
+	Name          *string                 `json:"name"`
+	UnitPrice     *float64                `json:"unit_price"`
+	Quantity      *int                    `json:"quantity"`
+	SKU           *string                 `json:"sku"`
+	Barcodes      *[]string               `json:"barcodes"`
+	CategoryID    *int                    `json:"category_id"`
+	BaseUnit      *string                 `json:"base_unit"`
+	PurchaseUnits *map[string]float64     `json:"purchase_units"`
+	Attributes    *map[string]interface{} `json:"attributes"`
+	Reason        string                  `json:"reason"`
+	// WarehouseID is where a quantity change is counted; 0 is the default warehouse
+	WarehouseID int `json:"warehouse_id"`
 }
 
 type Product struct {
-	ID        int     `json:"id"`
-	Name      string  `json:"name"`
-	UnitPrice float64 `json:"unit_price"`
-	Quantity  int     `json:"quantity"`
-	CreatedAt string  `json:"created_at"`
-	UpdatedAt string  `json:"updated_at"`
-	DeletedAt string  `json:"deleted_at"`
+	ID            int                    `json:"id"`
+	Name          string                 `json:"name"`
+	UnitPrice     float64                `json:"unit_price"`
+	Quantity      int                    `json:"quantity"`
+	Version       int                    `json:"version"`
+	SKU           string                 `json:"sku"`
+	Barcodes      []string               `json:"barcodes"`
+	CategoryID    *int                   `json:"category_id"`
+	BaseUnit      string                 `json:"base_unit"`
+	PurchaseUnits map[string]float64     `json:"purchase_units"`
+	Attributes    map[string]interface{} `json:"attributes"`
+	CreatedAt     string                 `json:"created_at"`
+	UpdatedAt     string                 `json:"updated_at"`
+	DeletedAt     *time.Time             `json:"deleted_at"`
+}
+
+// ProductSortColumns are the columns a product listing can be sorted on
+var ProductSortColumns = []string{"id", "sku", "name", "unit_price", "quantity", "created_at", "updated_at"}
+
+// ProductCursor marks the last product of a page: its value in the sort
+// column, as Postgres text, and its ID as the tie-breaker
+type ProductCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// ProductQuery filters, sorts and pages a product listing
+type ProductQuery struct {
+	// Search is a full-text query on the product name
+	Search string
+	// Name matches products whose name contains it, ignoring case
+	Name    string
+	SKU     string
+	Barcode string
+	// CategoryID matches products in the category or any of its subcategories
+	CategoryID *int
+	// Attributes match products whose attribute has the value, compared as text
+	Attributes     map[string]string
+	MinPrice       *float64
+	MaxPrice       *float64
+	MinQuantity    *int
+	MaxQuantity    *int
+	IncludeDeleted bool
+	OnlyDeleted    bool
+	Sort           string
+	Desc           bool
+	Limit          int
+	// After continues the listing after this product
+	After *ProductCursor
+}
+
+// ProductPage is one page of a product listing
+type ProductPage struct {
+	Products []Product
+	Total    int
+	// Next is set when more products follow the page
+	Next *ProductCursor
 }
 
 type StockRequest struct {
 	Quantity int    `json:"quantity"`
 	Reason   string `json:"reason"`
+	// UnitCost is optional and defaults to the product's unit price
+	UnitCost float64 `json:"unit_cost"`
+	// Unit is one of the product's purchase units; the quantity and unit
+	// cost are converted to the base unit. Empty means the base unit.
+	Unit string `json:"unit"`
+	// WarehouseID is the warehouse the stock goes into or comes out of; 0 is
+	// the default warehouse, or the bin's warehouse when BinID is set
+	WarehouseID int `json:"warehouse_id"`
+	// BinID puts added stock away in the bin, or takes removed stock from
+	// it. Without it stock is added unassigned and removed from unassigned
+	// stock first, then from bins along the walking path.
+	BinID int `json:"bin_id"`
 }
 
 type ProductResponse struct {
@@ -27,4 +127,52 @@ type ProductResponse struct {
 	Name      string  `json:"name"`
 	UnitPrice float64 `json:"unit_price"`
 	Quantity  int     `json:"quantity"`
+	// Version is the product's ETag value, to send back in If-Match
+	Version       int                    `json:"version"`
+	SKU           string                 `json:"sku"`
+	Barcodes      []string               `json:"barcodes"`
+	CategoryID    *int                   `json:"category_id"`
+	BaseUnit      string                 `json:"base_unit"`
+	PurchaseUnits map[string]float64     `json:"purchase_units"`
+	Attributes    map[string]interface{} `json:"attributes"`
+	DeletedAt     *time.Time             `json:"deleted_at,omitempty"`
+}
+
+type ProductListResponse struct {
+	Products   []ProductResponse `json:"products"`
+	Limit      int               `json:"limit"`
+	Total      int               `json:"total"`
+	HasMore    bool              `json:"has_more"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// InventoryItem is a product's stock at a point in time
+type InventoryItem struct {
+	ProductID int     `json:"product_id"`
+	Name      string  `json:"name"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+	Value     float64 `json:"value"`
+}
+
+type InventoryReport struct {
+	AsOf       time.Time       `json:"as_of"`
+	Items      []InventoryItem `json:"items"`
+	TotalValue float64         `json:"total_value"`
+}
+
+// StockLevel is a product's quantity after a ledger entry, or at the end of an interval
+type StockLevel struct {
+	At       time.Time     `json:"at"`
+	Quantity int           `json:"quantity"`
+	Type     OperationType `json:"operation_type,omitempty"`
+}
+
+type StockHistoryResponse struct {
+	ProductID int          `json:"product_id"`
+	From      time.Time    `json:"from"`
+	To        time.Time    `json:"to"`
+	Interval  string       `json:"interval,omitempty"`
+	Opening   int          `json:"opening"`
+	Levels    []StockLevel `json:"levels"`
 }
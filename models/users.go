@@ -1,14 +1,57 @@
 package models
 
+import "time"
+
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+)
+
 type User struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password,omitempty"`
+	ID        int        `json:"id"`
+	Username  string     `json:"username"`
+	Password  string     `json:"password,omitempty"`
+	Email     string     `json:"email,omitempty"`
+	FullName  string     `json:"full_name,omitempty"`
+	Status    string     `json:"status,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// UserUpdateRequest holds the profile fields PATCH /users/{username} may change
+type UserUpdateRequest struct {
+	Email    *string `json:"email"`
+	FullName *string `json:"full_name"`
+	Status   *string `json:"status"`
+}
+
+// UserFilter narrows down and pages user listings
+type UserFilter struct {
+	Username         string
+	UsernamePrefix   string
+	UsernameContains string
+	Search           string
+	Usernames        []string
+	Status           string
+	IncludeDeleted   bool
+	OnlyDeleted      bool
+	Offset           int
+	Limit            int
 }
 
 // Create extended response with user info and groups
 type UserResponse struct {
-	ID       int      `json:"id"`
-	Username string   `json:"username"`
-	Groups   []string `json:"groups"`
+	ID        int        `json:"id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	FullName  string     `json:"full_name"`
+	Status    string     `json:"status"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Groups    []string   `json:"groups"`
+}
+
+type UserListResponse struct {
+	Users    []UserResponse `json:"users"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Total    int            `json:"total"`
 }
@@ -0,0 +1,81 @@
+package models
+
+import "time"
+
+// Warehouse is a location that holds stock. Stock changes that name no
+// warehouse go to the default one.
+type Warehouse struct {
+	ID        int       `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	IsDefault bool      `json:"is_default"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WarehouseRequest struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// StockBalance is a product's quantity in one warehouse, split over its bins
+type StockBalance struct {
+	WarehouseID   int    `json:"warehouse_id"`
+	WarehouseCode string `json:"warehouse_code"`
+	Quantity      int    `json:"quantity"`
+	// Unassigned is the part of the quantity that is in no bin
+	Unassigned int          `json:"unassigned"`
+	Bins       []BinBalance `json:"bins"`
+}
+
+type ProductStockResponse struct {
+	ProductID int            `json:"product_id"`
+	Quantity  int            `json:"quantity"`
+	Balances  []StockBalance `json:"balances"`
+}
+
+// TransferRequest moves stock of a product from one warehouse to another
+type TransferRequest struct {
+	FromWarehouseID int    `json:"from_warehouse_id"`
+	ToWarehouseID   int    `json:"to_warehouse_id"`
+	Quantity        int    `json:"quantity"`
+	Reason          string `json:"reason"`
+	// Unit is one of the product's purchase units; empty means the base unit
+	Unit string `json:"unit"`
+}
+
+// StockTransfer is a completed transfer, recorded in the ledger as a
+// TRANSFER_OUT and a TRANSFER_IN entry
+type StockTransfer struct {
+	ID              int       `json:"id"`
+	ProductID       int       `json:"product_id"`
+	FromWarehouseID int       `json:"from_warehouse_id"`
+	ToWarehouseID   int       `json:"to_warehouse_id"`
+	Quantity        int       `json:"quantity"`
+	Reason          string    `json:"reason"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// WarehouseStockItem is a product's stock in a warehouse
+type WarehouseStockItem struct {
+	ProductID int     `json:"product_id"`
+	SKU       string  `json:"sku"`
+	Name      string  `json:"name"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+	Value     float64 `json:"value"`
+}
+
+type WarehouseStockReport struct {
+	Warehouse     Warehouse            `json:"warehouse"`
+	Items         []WarehouseStockItem `json:"items"`
+	TotalQuantity int                  `json:"total_quantity"`
+	TotalValue    float64              `json:"total_value"`
+}
+
+// WarehouseSummary totals the stock held in a warehouse
+type WarehouseSummary struct {
+	Warehouse     Warehouse `json:"warehouse"`
+	Products      int       `json:"products"`
+	TotalQuantity int       `json:"total_quantity"`
+	TotalValue    float64   `json:"total_value"`
+}
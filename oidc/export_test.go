@@ -0,0 +1,10 @@
+package oidc
+
+import "time"
+
+// ExpireKeyRefresh lets the next unknown kid refresh the JWKS at once
+func (p *Provider) ExpireKeyRefresh() {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+	p.refreshedAt = time.Now().Add(-minKeyRefreshInterval)
+}
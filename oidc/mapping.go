@@ -0,0 +1,67 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// MappingRule maps one IdP group claim value to one Casbin group
+type MappingRule struct {
+	ClaimGroup  string `json:"claim_group"`
+	CasbinGroup string `json:"casbin_group"`
+}
+
+// GroupMapping is the set of rules applied to a user at every OIDC login
+type GroupMapping struct {
+	Rules []MappingRule `json:"rules"`
+}
+
+// LoadGroupMapping reads mapping rules from a JSON file.
+// A missing file means no groups are managed by the IdP.
+func LoadGroupMapping(path string) (*GroupMapping, error) {
+	mapping := &GroupMapping{}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return mapping, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group mapping: %v", err)
+	}
+
+	if err := json.Unmarshal(data, mapping); err != nil {
+		return nil, fmt.Errorf("invalid group mapping: %v", err)
+	}
+	return mapping, nil
+}
+
+// Resolve splits the managed Casbin groups into those the user should and should not hold
+func (m *GroupMapping) Resolve(claimGroups []string) (grant []string, revoke []string) {
+	member := make(map[string]bool)
+	for _, g := range claimGroups {
+		member[g] = true
+	}
+
+	granted := make(map[string]bool)
+	for _, rule := range m.Rules {
+		if member[rule.ClaimGroup] {
+			granted[rule.CasbinGroup] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, rule := range m.Rules {
+		if seen[rule.CasbinGroup] {
+			continue
+		}
+		seen[rule.CasbinGroup] = true
+
+		if granted[rule.CasbinGroup] {
+			grant = append(grant, rule.CasbinGroup)
+		} else {
+			revoke = append(revoke, rule.CasbinGroup)
+		}
+	}
+	return grant, revoke
+}
@@ -0,0 +1,197 @@
+// Package oidctest runs a minimal OpenID provider for tests of the relying party
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Server is an IdP that signs in whoever Authorize is called for. Its token
+// endpoint checks the PKCE verifier against the challenge of the authorization
+// request, as a real IdP does.
+type Server struct {
+	*httptest.Server
+	ClientID string
+
+	mu    sync.Mutex
+	key   *rsa.PrivateKey
+	kid   string
+	codes map[string]grant
+
+	jwksRequests atomic.Int32
+}
+
+type grant struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	claims        jwt.MapClaims
+}
+
+// NewServer starts an IdP for one client. Close it when done.
+func NewServer(clientID string) *Server {
+	s := &Server{ClientID: clientID, codes: make(map[string]grant)}
+	s.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/token", s.token)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Issuer is the server's issuer identifier
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// JWKSRequests counts the fetches of the signing keys
+func (s *Server) JWKSRequests() int {
+	return int(s.jwksRequests.Load())
+}
+
+// RotateKey replaces the signing key with a new one under a new kid
+func (s *Server) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = key
+	s.kid = fmt.Sprintf("key-%d", time.Now().UnixNano())
+}
+
+// Claims returns valid ID token claims for a subject
+func (s *Server) Claims(subject string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": s.Issuer(),
+		"aud": s.ClientID,
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+// Sign signs claims with the current key
+func (s *Server) Sign(claims jwt.MapClaims) string {
+	s.mu.Lock()
+	key, kid := s.key, s.kid
+	s.mu.Unlock()
+	return SignWith(key, kid, claims)
+}
+
+// SignWith signs claims with any RSA key, announcing kid in the header
+func SignWith(key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+// Authorize plays the user signing in at the authorization URL the relying
+// party redirected to. It returns the callback query the IdP would redirect
+// back with; the ID token for its code carries claims and the request's nonce.
+func (s *Server) Authorize(authURL string, claims jwt.MapClaims) (url.Values, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	if params.Get("client_id") != s.ClientID {
+		return nil, fmt.Errorf("unknown client %q", params.Get("client_id"))
+	}
+	if params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "" {
+		return nil, fmt.Errorf("authorization request has no S256 code challenge")
+	}
+
+	idClaims := jwt.MapClaims{}
+	for name, value := range claims {
+		idClaims[name] = value
+	}
+	idClaims["nonce"] = params.Get("nonce")
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	s.mu.Lock()
+	s.codes[code] = grant{
+		clientID:      s.ClientID,
+		redirectURI:   params.Get("redirect_uri"),
+		codeChallenge: params.Get("code_challenge"),
+		claims:        idClaims,
+	}
+	s.mu.Unlock()
+
+	return url.Values{"code": {code}, "state": {params.Get("state")}}, nil
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 s.Issuer(),
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	s.jwksRequests.Add(1)
+
+	s.mu.Lock()
+	key, kid := s.key, s.kid
+	s.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": kid,
+			"kty": "RSA",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+}
+
+// token redeems a code once, and only with the verifier of its challenge
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	g, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok,
+		r.PostForm.Get("grant_type") != "authorization_code",
+		r.PostForm.Get("client_id") != g.clientID,
+		r.PostForm.Get("redirect_uri") != g.redirectURI,
+		base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge:
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": s.Sign(g.claims), "token_type": "Bearer"})
+}
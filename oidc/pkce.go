@@ -0,0 +1,88 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+const loginSessionTTL = 10 * time.Minute
+
+// LoginSession is the state kept between the redirect to the IdP and the callback
+type LoginSession struct {
+	CodeVerifier string
+	Nonce        string
+	ExpiresAt    time.Time
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[string]LoginSession)
+)
+
+// RandomString returns a URL-safe random string built from n random bytes
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge derives the S256 PKCE challenge for a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// StartLogin creates a login session and returns its state key
+func StartLogin() (string, LoginSession, error) {
+	state, err := RandomString(24)
+	if err != nil {
+		return "", LoginSession{}, err
+	}
+	verifier, err := RandomString(32)
+	if err != nil {
+		return "", LoginSession{}, err
+	}
+	nonce, err := RandomString(24)
+	if err != nil {
+		return "", LoginSession{}, err
+	}
+
+	session := LoginSession{
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(loginSessionTTL),
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for key, s := range sessions {
+		if time.Now().After(s.ExpiresAt) {
+			delete(sessions, key)
+		}
+	}
+	sessions[state] = session
+
+	return state, session, nil
+}
+
+// FinishLogin consumes the login session for a state, so it can only be used once
+func FinishLogin(state string) (LoginSession, bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	session, ok := sessions[state]
+	if !ok {
+		return LoginSession{}, false
+	}
+	delete(sessions, state)
+
+	if time.Now().After(session.ExpiresAt) {
+		return LoginSession{}, false
+	}
+	return session, true
+}
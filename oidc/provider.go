@@ -0,0 +1,390 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// minKeyRefreshInterval limits how often a token signed with an unknown key
+// can make us fetch the JWKS again
+const minKeyRefreshInterval = time.Minute
+
+// Config holds the relying-party settings for the company IdP
+type Config struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+	MappingFile   string
+}
+
+// discoveryDocument is the subset of /.well-known/openid-configuration we use
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Provider is an OIDC relying party bound to a single issuer
+type Provider struct {
+	config    Config
+	discovery discoveryDocument
+	client    *http.Client
+	mapping   *GroupMapping
+
+	mu   sync.RWMutex
+	keys map[string]interface{}
+
+	// refreshMu serializes JWKS refreshes; refreshedAt is the last attempt
+	refreshMu   sync.Mutex
+	refreshedAt time.Time
+}
+
+// Identity is the verified result of an OIDC login. Issuer and Subject
+// identify the IdP account; the other claims may change over time.
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Email    string
+	Groups   []string
+}
+
+var (
+	// GlobalProvider is the configured provider, nil when OIDC is disabled
+	GlobalProvider *Provider
+)
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// InitializeProvider configures the global provider from the environment.
+// OIDC stays disabled when OIDC_ISSUER is not set.
+func InitializeProvider() error {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		fmt.Println("OIDC login disabled")
+		return nil
+	}
+
+	config := Config{
+		Issuer:        issuer,
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:        strings.Fields(getEnvOrDefault("OIDC_SCOPES", "openid profile email groups")),
+		UsernameClaim: getEnvOrDefault("OIDC_USERNAME_CLAIM", "preferred_username"),
+		GroupsClaim:   getEnvOrDefault("OIDC_GROUPS_CLAIM", "groups"),
+		MappingFile:   getEnvOrDefault("OIDC_GROUP_MAPPING", "./config/oidc_group_mapping.json"),
+	}
+
+	provider, err := NewProvider(config, http.DefaultClient)
+	if err != nil {
+		return err
+	}
+
+	GlobalProvider = provider
+	fmt.Println("OIDC provider initialized successfully")
+	return nil
+}
+
+// GetProvider returns the global provider instance
+func GetProvider() *Provider {
+	return GlobalProvider
+}
+
+// NewProvider fetches the issuer's discovery document and group mapping rules
+func NewProvider(config Config, client *http.Client) (*Provider, error) {
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC client ID and redirect URL are required")
+	}
+
+	mapping, err := LoadGroupMapping(config.MappingFile)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		config:  config,
+		client:  client,
+		mapping: mapping,
+		keys:    make(map[string]interface{}),
+	}
+
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(discoveryURL, &p.discovery); err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document: %v", err)
+	}
+	if p.discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: expected %s, got %s", config.Issuer, p.discovery.Issuer)
+	}
+
+	if err := p.refreshKeys(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Mapping returns the IdP group to Casbin group mapping rules
+func (p *Provider) Mapping() *GroupMapping {
+	return p.mapping
+}
+
+// AuthCodeURL builds the authorization request URL for the code flow with PKCE
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange redeems an authorization code and returns the verified identity
+func (p *Provider) Exchange(code, codeVerifier, nonce string) (*Identity, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	resp, err := p.client.PostForm(p.discovery.TokenEndpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return nil, fmt.Errorf("invalid token response: %v", err)
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	return p.VerifyIDToken(tokenResponse.IDToken, nonce)
+}
+
+// VerifyIDToken checks the ID token signature, issuer, audience, expiry and nonce
+func (p *Provider) VerifyIDToken(rawToken, nonce string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, p.keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+
+	if !claims.VerifyIssuer(p.config.Issuer, true) {
+		return nil, fmt.Errorf("invalid id token issuer")
+	}
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, fmt.Errorf("invalid id token audience")
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("id token expired")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, fmt.Errorf("invalid id token nonce")
+	}
+
+	identity := &Identity{Issuer: p.config.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("id token has no sub claim")
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.Username, _ = claims[p.config.UsernameClaim].(string)
+	if identity.Username == "" {
+		identity.Username = identity.Email
+	}
+	if identity.Username == "" {
+		return nil, fmt.Errorf("id token has no %s claim", p.config.UsernameClaim)
+	}
+
+	switch groups := claims[p.config.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if name, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = strings.Fields(groups)
+	}
+
+	return identity, nil
+}
+
+// keyFunc looks up the signing key by kid, refreshing the JWKS on a miss at
+// most once per minKeyRefreshInterval so forged kids cannot hammer the IdP
+func (p *Provider) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if key, ok := p.key(kid); ok {
+		return key, nil
+	}
+
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	// Another login may have refreshed the keys while this one waited
+	if key, ok := p.key(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.refreshedAt) < minKeyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if err := p.refreshKeys(); err != nil {
+		return nil, err
+	}
+	if key, ok := p.key(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (p *Provider) key(kid string) (interface{}, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// refreshKeys fetches the issuer's JWKS. Callers other than NewProvider hold refreshMu.
+func (p *Provider) refreshKeys() error {
+	p.refreshedAt = time.Now()
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(p.discovery.JwksURI, &jwks); err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			fmt.Println("Skipping signing key", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Provider) getJSON(url string, v interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
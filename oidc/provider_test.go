@@ -0,0 +1,227 @@
+package oidc_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"casbin-demo/oidc"
+	"casbin-demo/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const clientID = "inventory"
+
+func newProvider(t *testing.T) (*oidc.Provider, *oidctest.Server) {
+	t.Helper()
+
+	idp := oidctest.NewServer(clientID)
+	t.Cleanup(idp.Close)
+
+	provider, err := oidc.NewProvider(oidc.Config{
+		Issuer:        idp.Issuer(),
+		ClientID:      clientID,
+		RedirectURL:   "https://inventory.example.com/auth/oidc/callback",
+		Scopes:        []string{"openid"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		MappingFile:   t.TempDir() + "/none.json",
+	}, idp.Client())
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	return provider, idp
+}
+
+func TestLoginStateIsSingleUse(t *testing.T) {
+	state, session, err := oidc.StartLogin()
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+
+	if _, ok := oidc.FinishLogin("forged-state"); ok {
+		t.Fatal("unknown state accepted")
+	}
+	got, ok := oidc.FinishLogin(state)
+	if !ok || got.CodeVerifier != session.CodeVerifier || got.Nonce != session.Nonce {
+		t.Fatalf("FinishLogin(state) = %+v, %v; want the started session", got, ok)
+	}
+	if _, ok := oidc.FinishLogin(state); ok {
+		t.Fatal("state accepted twice")
+	}
+}
+
+func TestAuthCodeURLSendsS256Challenge(t *testing.T) {
+	provider, _ := newProvider(t)
+
+	authURL, err := url.Parse(provider.AuthCodeURL("the-state", "the-nonce", oidc.CodeChallenge("the-verifier")))
+	if err != nil {
+		t.Fatalf("AuthCodeURL is not a URL: %v", err)
+	}
+	params := authURL.Query()
+
+	sum := sha256.Sum256([]byte("the-verifier"))
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             clientID,
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(sum[:]),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := params.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestExchangeRequiresCodeVerifier(t *testing.T) {
+	provider, idp := newProvider(t)
+
+	login := func() (url.Values, oidc.LoginSession) {
+		t.Helper()
+		state, session, err := oidc.StartLogin()
+		if err != nil {
+			t.Fatalf("StartLogin: %v", err)
+		}
+		claims := idp.Claims("subject-1")
+		claims["preferred_username"] = "alice"
+		callback, err := idp.Authorize(provider.AuthCodeURL(state, session.Nonce, oidc.CodeChallenge(session.CodeVerifier)), claims)
+		if err != nil {
+			t.Fatalf("Authorize: %v", err)
+		}
+		return callback, session
+	}
+
+	callback, session := login()
+	identity, err := provider.Exchange(callback.Get("code"), session.CodeVerifier, session.Nonce)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if identity.Issuer != idp.Issuer() || identity.Subject != "subject-1" || identity.Username != "alice" {
+		t.Errorf("identity = %+v", identity)
+	}
+	if _, err := provider.Exchange(callback.Get("code"), session.CodeVerifier, session.Nonce); err == nil {
+		t.Error("code redeemed twice")
+	}
+
+	callback, session = login()
+	if _, err := provider.Exchange(callback.Get("code"), "another-verifier", session.Nonce); err == nil {
+		t.Error("code redeemed without its verifier")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	provider, idp := newProvider(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() jwt.MapClaims {
+		claims := idp.Claims("subject-1")
+		claims["preferred_username"] = "alice"
+		claims["email"] = "alice@example.com"
+		claims["groups"] = []string{"warehouse-staff"}
+		claims["nonce"] = "the-nonce"
+		return claims
+	}
+	with := func(name string, value interface{}) string {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return idp.Sign(claims)
+	}
+
+	identity, err := provider.VerifyIDToken(idp.Sign(valid()), "the-nonce")
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if identity.Subject != "subject-1" || identity.Username != "alice" || len(identity.Groups) != 1 {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	// The signing key's kid with a different key is a forged signature
+	forged, err := jwt.Parse(idp.Sign(valid()), nil)
+	if forged == nil {
+		t.Fatalf("parse own token: %v", err)
+	}
+	kid, _ := forged.Header["kid"].(string)
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"forged signature", oidctest.SignWith(otherKey, kid, valid()), "the-nonce"},
+		{"unsigned", unsigned(valid()), "the-nonce"},
+		{"other audience", with("aud", "another-client"), "the-nonce"},
+		{"no audience", with("aud", nil), "the-nonce"},
+		{"other issuer", with("iss", "https://evil.example.com"), "the-nonce"},
+		{"expired", with("exp", time.Now().Add(-time.Minute).Unix()), "the-nonce"},
+		{"no expiry", with("exp", nil), "the-nonce"},
+		{"other nonce", idp.Sign(valid()), "another-nonce"},
+		{"no subject", with("sub", nil), "the-nonce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if identity, err := provider.VerifyIDToken(tt.token, tt.nonce); err == nil {
+				t.Errorf("accepted, identity %+v", identity)
+			}
+		})
+	}
+}
+
+func TestUnknownKeyRefreshIsRateLimited(t *testing.T) {
+	provider, idp := newProvider(t)
+	if got := idp.JWKSRequests(); got != 1 {
+		t.Fatalf("JWKS fetched %d times by NewProvider, want 1", got)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := idp.Claims("subject-1")
+	claims["preferred_username"] = "alice"
+	claims["nonce"] = "n"
+
+	for i := 0; i < 10; i++ {
+		if _, err := provider.VerifyIDToken(oidctest.SignWith(otherKey, "unknown-"+strings.Repeat("x", i), claims), "n"); err == nil {
+			t.Fatal("token with an unknown key accepted")
+		}
+	}
+	if got := idp.JWKSRequests(); got != 1 {
+		t.Errorf("JWKS fetched %d times after unknown kids, want 1", got)
+	}
+
+	// A rotated key is picked up once the interval has passed
+	idp.RotateKey()
+	provider.ExpireKeyRefresh()
+	if _, err := provider.VerifyIDToken(idp.Sign(claims), "n"); err != nil {
+		t.Fatalf("token signed with the rotated key rejected: %v", err)
+	}
+	if got := idp.JWKSRequests(); got != 2 {
+		t.Errorf("JWKS fetched %d times after rotation, want 2", got)
+	}
+}
+
+// unsigned returns a token with the "none" algorithm
+func unsigned(claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
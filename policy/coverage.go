@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"strings"
+
+	"casbin-demo/enforcer"
+	"casbin-demo/routes"
+
+	"github.com/casbin/casbin/v2/util"
+)
+
+// CoverageReport lists gaps between the protected routes and the loaded p rules
+type CoverageReport struct {
+	// Unreachable routes have no permission or no allow rule granting it
+	Unreachable []routes.Route `json:"unreachable"`
+	// WildcardOnly routes are only granted by rules whose object has a *, such
+	// as root's * or a permissions:* prefix rule
+	WildcardOnly []routes.Route `json:"wildcard_only"`
+	// UnusedRules are p rules that grant no route
+	UnusedRules [][]string `json:"unused_rules"`
+}
+
+// OK reports whether the check found nothing
+func (c CoverageReport) OK() bool {
+	return len(c.Unreachable) == 0 && len(c.WildcardOnly) == 0 && len(c.UnusedRules) == 0
+}
+
+// ruleGrants reports whether a p rule (sub, obj, act, eft, ...) applies to a route,
+// using the same object and action matching as the model
+func ruleGrants(rule []string, route routes.Route) bool {
+	if len(rule) < 3 || route.Permission == "" {
+		return false
+	}
+	if rule[2] != "*" && rule[2] != route.Method {
+		return false
+	}
+	return rule[1] == route.Permission || util.KeyMatch(route.Permission, rule[1])
+}
+
+// CheckCoverage matches every route against the p rules
+func CheckCoverage(rules [][]string, walked []routes.Route) CoverageReport {
+	report := CoverageReport{
+		Unreachable:  []routes.Route{},
+		WildcardOnly: []routes.Route{},
+		UnusedRules:  [][]string{},
+	}
+	used := make([]bool, len(rules))
+
+	for _, route := range walked {
+		granted, explicit := false, false
+		for i, rule := range rules {
+			if !ruleGrants(rule, route) {
+				continue
+			}
+			used[i] = true
+
+			if len(rule) < 4 || rule[3] != "allow" {
+				continue
+			}
+			granted = true
+			if !strings.Contains(rule[1], "*") {
+				explicit = true
+			}
+		}
+
+		switch {
+		case !granted:
+			report.Unreachable = append(report.Unreachable, route)
+		case !explicit:
+			report.WildcardOnly = append(report.WildcardOnly, route)
+		}
+	}
+
+	for i, rule := range rules {
+		// Group and warehouse scopes are not route rules
+		if used[i] || (len(rule) > 1 && enforcer.IsScopeObject(rule[1])) {
+			continue
+		}
+		report.UnusedRules = append(report.UnusedRules, rule)
+	}
+
+	return report
+}
+
+// Coverage runs CheckCoverage on the live enforcer and the registered routes
+func Coverage(reg *routes.Registry) (CoverageReport, error) {
+	walked, err := reg.Walk()
+	if err != nil {
+		return CoverageReport{}, err
+	}
+
+	rules, err := enforcer.GetEnforcer().GetPolicy()
+	if err != nil {
+		return CoverageReport{}, err
+	}
+
+	return CheckCoverage(rules, walked), nil
+}
+
+// Findings describes each gap on its own line
+func (c CoverageReport) Findings() []string {
+	var findings []string
+	for _, route := range c.Unreachable {
+		if route.Permission == "" {
+			findings = append(findings, "route has no permission: "+route.Method+" "+route.Path)
+			continue
+		}
+		findings = append(findings, "route is unreachable: "+route.Method+" "+route.Path+" ("+route.Permission+")")
+	}
+	for _, route := range c.WildcardOnly {
+		findings = append(findings, "route is only granted by wildcard rules: "+route.Method+" "+route.Path+" ("+route.Permission+")")
+	}
+	for _, rule := range c.UnusedRules {
+		findings = append(findings, "rule matches no route: p, "+strings.Join(rule, ", "))
+	}
+	return findings
+}
@@ -0,0 +1,265 @@
+package policy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"casbin-demo/enforcer"
+	"casbin-demo/routes"
+)
+
+const (
+	allowCell = "allow"
+	denyCell  = "deny"
+)
+
+// MatrixRow is the access every subject has to one route
+type MatrixRow struct {
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Permission string            `json:"permission"`
+	Access     map[string]string `json:"access"`
+}
+
+// Matrix is the allow/deny decision of every subject for every route
+type Matrix struct {
+	Subjects []string    `json:"subjects"`
+	Rows     []MatrixRow `json:"rows"`
+}
+
+// EnforceFunc decides a single request
+type EnforceFunc func(sub, obj, act string) (bool, error)
+
+// BuildMatrix evaluates every subject against every route
+func BuildMatrix(subjects []string, walked []routes.Route, enforce EnforceFunc) (Matrix, error) {
+	matrix := Matrix{Subjects: subjects, Rows: make([]MatrixRow, 0, len(walked))}
+
+	for _, route := range walked {
+		row := MatrixRow{
+			Method:     route.Method,
+			Path:       route.Path,
+			Permission: route.Permission,
+			Access:     make(map[string]string, len(subjects)),
+		}
+
+		for _, subject := range subjects {
+			allowed := false
+			if route.Permission != "" {
+				var err error
+				if allowed, err = enforce(subject, route.Permission, route.Method); err != nil {
+					return matrix, err
+				}
+			}
+
+			row.Access[subject] = denyCell
+			if allowed {
+				row.Access[subject] = allowCell
+			}
+		}
+		matrix.Rows = append(matrix.Rows, row)
+	}
+	return matrix, nil
+}
+
+// Subjects returns every role followed by every user known to the policy
+func Subjects() ([]string, error) {
+	e := enforcer.GetEnforcer()
+
+	roles, err := e.GetAllRoles()
+	if err != nil {
+		return nil, err
+	}
+	isRole := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		isRole[role] = true
+	}
+
+	grouping, err := e.GetGroupingPolicy()
+	if err != nil {
+		return nil, err
+	}
+	subjects, err := e.GetAllSubjects()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var users []string
+	for _, rule := range grouping {
+		subjects = append(subjects, rule[0])
+	}
+	for _, subject := range subjects {
+		if isRole[subject] || seen[subject] {
+			continue
+		}
+		seen[subject] = true
+		users = append(users, subject)
+	}
+
+	sort.Strings(roles)
+	sort.Strings(users)
+	return append(roles, users...), nil
+}
+
+// AccessMatrix builds the matrix for all roles and users with the live enforcer.
+// Requests carry no environment, so cells show unconditional access only.
+func AccessMatrix(reg *routes.Registry) (Matrix, error) {
+	walked, err := reg.Walk()
+	if err != nil {
+		return Matrix{}, err
+	}
+
+	subjects, err := Subjects()
+	if err != nil {
+		return Matrix{}, err
+	}
+
+	return BuildMatrix(subjects, walked, func(sub, obj, act string) (bool, error) {
+		return enforcer.GetEnforcer().Enforce(sub, obj, act)
+	})
+}
+
+// Write renders the matrix as csv, json or markdown
+func (m Matrix) Write(w io.Writer, format string) error {
+	switch format {
+	case "csv", "":
+		return m.WriteCSV(w)
+	case "json":
+		return json.NewEncoder(w).Encode(m)
+	case "markdown", "md":
+		return m.WriteMarkdown(w)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// WriteCSV writes one row per route with a column per subject
+func (m Matrix) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append([]string{"method", "path", "permission"}, m.Subjects...)); err != nil {
+		return err
+	}
+
+	for _, row := range m.Rows {
+		record := []string{row.Method, row.Path, row.Permission}
+		for _, subject := range m.Subjects {
+			record = append(record, row.Access[subject])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteMarkdown writes the matrix as a Markdown table
+func (m Matrix) WriteMarkdown(w io.Writer) error {
+	header := append([]string{"Method", "Path", "Permission"}, m.Subjects...)
+	fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | "))
+	fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(header)))
+
+	for _, row := range m.Rows {
+		cells := []string{row.Method, "`" + row.Path + "`", row.Permission}
+		for _, subject := range m.Subjects {
+			cell := "✗"
+			if row.Access[subject] == allowCell {
+				cell = "✓"
+			}
+			cells = append(cells, cell)
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadMatrixCSV parses a matrix written by WriteCSV
+func ReadMatrixCSV(r io.Reader) (Matrix, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return Matrix{}, err
+	}
+	if len(records) == 0 || len(records[0]) < 3 {
+		return Matrix{}, fmt.Errorf("access matrix has no header")
+	}
+
+	matrix := Matrix{Subjects: records[0][3:]}
+	for _, record := range records[1:] {
+		if len(record) != len(records[0]) {
+			return Matrix{}, fmt.Errorf("access matrix row %v has %d columns, expected %d", record[:3], len(record), len(records[0]))
+		}
+
+		row := MatrixRow{
+			Method:     record[0],
+			Path:       record[1],
+			Permission: record[2],
+			Access:     make(map[string]string, len(matrix.Subjects)),
+		}
+		for i, subject := range matrix.Subjects {
+			row.Access[subject] = record[3+i]
+		}
+		matrix.Rows = append(matrix.Rows, row)
+	}
+	return matrix, nil
+}
+
+// Diff lists every cell, route and subject that differs from baseline
+func Diff(baseline, current Matrix) []string {
+	var changes []string
+
+	key := func(row MatrixRow) string { return row.Method + " " + row.Path }
+	baselineRows := make(map[string]MatrixRow, len(baseline.Rows))
+	for _, row := range baseline.Rows {
+		baselineRows[key(row)] = row
+	}
+	currentRows := make(map[string]bool, len(current.Rows))
+
+	inBaseline := make(map[string]bool, len(baseline.Subjects))
+	for _, subject := range baseline.Subjects {
+		inBaseline[subject] = true
+	}
+	inCurrent := make(map[string]bool, len(current.Subjects))
+	for _, subject := range current.Subjects {
+		inCurrent[subject] = true
+		if !inBaseline[subject] {
+			changes = append(changes, "+ subject "+subject)
+		}
+	}
+	for _, subject := range baseline.Subjects {
+		if !inCurrent[subject] {
+			changes = append(changes, "- subject "+subject)
+		}
+	}
+
+	for _, row := range current.Rows {
+		currentRows[key(row)] = true
+		old, ok := baselineRows[key(row)]
+		if !ok {
+			changes = append(changes, "+ route "+key(row))
+			continue
+		}
+
+		for _, subject := range current.Subjects {
+			if !inBaseline[subject] {
+				continue
+			}
+			if old.Access[subject] != row.Access[subject] {
+				changes = append(changes, fmt.Sprintf("~ %s %s: %s -> %s", key(row), subject, old.Access[subject], row.Access[subject]))
+			}
+		}
+	}
+
+	for _, row := range baseline.Rows {
+		if !currentRows[key(row)] {
+			changes = append(changes, "- route "+key(row))
+		}
+	}
+	return changes
+}
@@ -0,0 +1,200 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"casbin-demo/enforcer"
+	"casbin-demo/routes"
+)
+
+// Suite is a declarative set of authorization cases run against a model and policy.
+// Model and policy paths are relative to the suite file.
+type Suite struct {
+	Model    string       `json:"model"`
+	Policy   string       `json:"policy"`
+	Fixtures SuiteFixture `json:"fixtures"`
+	Cases    []SuiteCase  `json:"cases"`
+}
+
+// SuiteFixture adds role assignments and p rules on top of the policy for the
+// duration of the suite
+type SuiteFixture struct {
+	Roles    map[string][]string `json:"roles"`
+	Policies [][]string          `json:"policies"`
+}
+
+// SuiteCase is a single expected decision. A case names either a path and
+// method, resolved to a permission through the route registry, or the
+// object and action to enforce directly. IP and Time (RFC 3339) set the
+// environment that conditional rules are checked against.
+type SuiteCase struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Path    string `json:"path,omitempty"`
+	Method  string `json:"method,omitempty"`
+	Object  string `json:"object,omitempty"`
+	Action  string `json:"action,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Time    string `json:"time,omitempty"`
+	Expect  string `json:"expect"`
+}
+
+// CaseResult is the outcome of one case
+type CaseResult struct {
+	Case        SuiteCase `json:"case"`
+	Passed      bool      `json:"passed"`
+	Explanation string    `json:"explanation,omitempty"`
+}
+
+// SuiteResult is the outcome of a suite
+type SuiteResult struct {
+	Cases []CaseResult `json:"cases"`
+}
+
+// Failures returns the cases that did not get the expected decision
+func (r SuiteResult) Failures() []CaseResult {
+	var failures []CaseResult
+	for _, c := range r.Cases {
+		if !c.Passed {
+			failures = append(failures, c)
+		}
+	}
+	return failures
+}
+
+// LoadSuite reads a JSON suite file and resolves its model and policy paths
+func LoadSuite(path string) (Suite, error) {
+	var suite Suite
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return suite, fmt.Errorf("failed to read suite: %v", err)
+	}
+	if err := json.Unmarshal(data, &suite); err != nil {
+		return suite, fmt.Errorf("invalid suite %s: %v", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	if suite.Model == "" {
+		suite.Model = enforcer.DefaultModelPath
+	} else if !filepath.IsAbs(suite.Model) {
+		suite.Model = filepath.Join(dir, suite.Model)
+	}
+	if suite.Policy == "" {
+		suite.Policy = enforcer.DefaultPolicyPath
+	} else if !filepath.IsAbs(suite.Policy) {
+		suite.Policy = filepath.Join(dir, suite.Policy)
+	}
+	return suite, nil
+}
+
+// RunSuiteFile loads and runs a suite file. reg may be nil when no case uses a path.
+func RunSuiteFile(path string, reg *routes.Registry) (SuiteResult, error) {
+	suite, err := LoadSuite(path)
+	if err != nil {
+		return SuiteResult{}, err
+	}
+	return RunSuite(suite, reg)
+}
+
+// RunSuite evaluates every case on a fresh enforcer built from the suite's
+// model, policy and fixtures, leaving the live enforcer untouched
+func RunSuite(suite Suite, reg *routes.Registry) (SuiteResult, error) {
+	e, err := enforcer.NewEnforcer(suite.Model, suite.Policy)
+	if err != nil {
+		return SuiteResult{}, err
+	}
+
+	// Fixtures only live in memory; the policy file is never saved
+	e.EnableAutoSave(false)
+	for user, roles := range suite.Fixtures.Roles {
+		for _, role := range roles {
+			if _, err := e.AddGroupingPolicy(user, role); err != nil {
+				return SuiteResult{}, fmt.Errorf("invalid fixture %s -> %s: %v", user, role, err)
+			}
+		}
+	}
+
+	for _, rule := range suite.Fixtures.Policies {
+		if _, err := e.AddPolicy(enforcer.NormalizeRuleFor(e, rule)); err != nil {
+			return SuiteResult{}, fmt.Errorf("invalid fixture rule %v: %v", rule, err)
+		}
+	}
+
+	result := SuiteResult{Cases: make([]CaseResult, 0, len(suite.Cases))}
+	for _, c := range suite.Cases {
+		result.Cases = append(result.Cases, runCase(e, reg, c))
+	}
+	return result, nil
+}
+
+func runCase(e *enforcer.Enforcer, reg *routes.Registry, c SuiteCase) CaseResult {
+	result := CaseResult{Case: c}
+
+	expectAllow := strings.EqualFold(c.Expect, "allow")
+	if !expectAllow && !strings.EqualFold(c.Expect, "deny") {
+		result.Explanation = fmt.Sprintf("expect must be allow or deny, got %q", c.Expect)
+		return result
+	}
+
+	object, action := c.Object, c.Action
+	if c.Path != "" {
+		if reg == nil {
+			result.Explanation = "case uses a path but no route registry was given"
+			return result
+		}
+
+		route, ok := reg.Resolve(c.Method, c.Path)
+		if !ok {
+			result.Explanation = fmt.Sprintf("no route serves %s %s", c.Method, c.Path)
+			return result
+		}
+		if route.Permission == "" {
+			// Authorize denies routes without a permission
+			result.Passed = !expectAllow
+			if !result.Passed {
+				result.Explanation = fmt.Sprintf("route %s %s has no permission and is always denied", route.Method, route.Path)
+			}
+			return result
+		}
+		object, action = route.Permission, c.Method
+	}
+
+	env := enforcer.Environment{IP: c.IP}
+	if c.Time != "" {
+		at, err := time.Parse(time.RFC3339, c.Time)
+		if err != nil {
+			result.Explanation = fmt.Sprintf("time must be RFC 3339, got %q", c.Time)
+			return result
+		}
+		env.Time = at
+	}
+
+	allowed, explain, err := e.EnforceExIn(env, c.Subject, object, action)
+	if err != nil {
+		result.Explanation = "enforce failed: " + err.Error()
+		return result
+	}
+
+	result.Passed = allowed == expectAllow
+	if result.Passed {
+		return result
+	}
+
+	roles, _ := e.GetImplicitRolesForUser(c.Subject)
+	request := fmt.Sprintf("%s %s %s", c.Subject, object, action)
+	switch {
+	case allowed:
+		result.Explanation = fmt.Sprintf("expected deny for %s, but rule [%s] allowed it (roles: %v)", request, strings.Join(explain, ", "), roles)
+	case len(explain) > 0:
+		result.Explanation = fmt.Sprintf("expected allow for %s, but rule [%s] denied it (roles: %v)", request, strings.Join(explain, ", "), roles)
+	default:
+		result.Explanation = fmt.Sprintf("expected allow for %s, but no rule matched (roles: %v)", request, roles)
+	}
+	return result
+}
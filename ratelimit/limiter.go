@@ -0,0 +1,209 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	requestWindow  = time.Minute
+	quantityWindow = 24 * time.Hour
+)
+
+// Limit caps what holders of a role may do through a permission. A dimension
+// left out has no opinion, so less specific limits of the role and the user's
+// other roles decide it; an explicit 0 makes it unlimited. Permission may end
+// in * to match every permission with that prefix, e.g. "stock:*" or "*".
+type Limit struct {
+	Role              string `json:"role"`
+	Permission        string `json:"permission"`
+	RequestsPerMinute *int64 `json:"requests_per_minute,omitempty"`
+	QuantityPerDay    *int64 `json:"quantity_per_day,omitempty"`
+}
+
+func requestsPerMinute(limit Limit) *int64 { return limit.RequestsPerMinute }
+
+func quantityPerDay(limit Limit) *int64 { return limit.QuantityPerDay }
+
+// Config is the set of limits loaded from the rate limit file
+type Config struct {
+	Limits []Limit `json:"limits"`
+}
+
+// RolesFunc returns the roles a user holds, directly or through other roles
+type RolesFunc func(user string) ([]string, error)
+
+// Limiter applies the configured limits using counters kept in a Store
+type Limiter struct {
+	config Config
+	store  Store
+	roles  RolesFunc
+}
+
+var (
+	// GlobalLimiter is the global limiter instance
+	GlobalLimiter *Limiter
+)
+
+// LoadConfig reads limits from a JSON file. A missing file means no limits.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("failed to read rate limits: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid rate limits: %v", err)
+	}
+	for _, limit := range config.Limits {
+		if limit.Role == "" || limit.Permission == "" {
+			return config, fmt.Errorf("invalid rate limits: every limit needs a role and a permission")
+		}
+		if limit.RequestsPerMinute == nil && limit.QuantityPerDay == nil {
+			return config, fmt.Errorf("invalid rate limits: %s %s sets no limit", limit.Role, limit.Permission)
+		}
+		if limit.RequestsPerMinute != nil && *limit.RequestsPerMinute < 0 ||
+			limit.QuantityPerDay != nil && *limit.QuantityPerDay < 0 {
+			return config, fmt.Errorf("invalid rate limits: %s %s has a negative limit", limit.Role, limit.Permission)
+		}
+	}
+	return config, nil
+}
+
+// InitializeLimiter loads the limits from RATE_LIMITS_CONFIG, or
+// ./config/rate_limits.json, into the global limiter
+func InitializeLimiter(roles RolesFunc) error {
+	path := os.Getenv("RATE_LIMITS_CONFIG")
+	if path == "" {
+		path = "./config/rate_limits.json"
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	GlobalLimiter = NewLimiter(config, NewMemoryStore(), roles)
+	fmt.Println("Rate limiter initialized with", len(config.Limits), "limits")
+	return nil
+}
+
+// GetLimiter returns the global limiter instance
+func GetLimiter() *Limiter {
+	return GlobalLimiter
+}
+
+// NewLimiter creates a limiter for config backed by store
+func NewLimiter(config Config, store Store, roles RolesFunc) *Limiter {
+	return &Limiter{config: config, store: store, roles: roles}
+}
+
+// Resolve returns the effective limit for user on permission, where a nil
+// dimension is not limited at all. Each dimension is resolved on its own:
+// every role of the user takes its most specific matching limit that sets
+// the dimension, and the most permissive of those wins. Roles with no such
+// limit do not count, so a leader inherits staff limits unless leader has its
+// own, and a role's "*" request limit does not drop a quota set elsewhere.
+func (l *Limiter) Resolve(user, permission string) (Limit, error) {
+	roles, err := l.roles(user)
+	if err != nil {
+		return Limit{}, err
+	}
+
+	effective := Limit{Permission: permission}
+	for _, role := range append([]string{user}, roles...) {
+		effective.RequestsPerMinute = mostPermissive(effective.RequestsPerMinute, l.match(role, permission, requestsPerMinute))
+		effective.QuantityPerDay = mostPermissive(effective.QuantityPerDay, l.match(role, permission, quantityPerDay))
+	}
+	return effective, nil
+}
+
+// match returns the dimension of role's limit with the longest permission
+// pattern that matches and sets the dimension, or nil if none does
+func (l *Limiter) match(role, permission string, dimension func(Limit) *int64) *int64 {
+	var best *int64
+	bestLength := -1
+	for _, limit := range l.config.Limits {
+		if limit.Role != role || dimension(limit) == nil {
+			continue
+		}
+
+		length := len(limit.Permission)
+		if limit.Permission != permission {
+			prefix, ok := strings.CutSuffix(limit.Permission, "*")
+			if !ok || !strings.HasPrefix(permission, prefix) {
+				continue
+			}
+			length = len(prefix)
+		}
+
+		if length > bestLength {
+			best, bestLength = dimension(limit), length
+		}
+	}
+	return best
+}
+
+// AllowRequest counts one request by user through permission
+func (l *Limiter) AllowRequest(user, permission string) (Result, error) {
+	limit, err := l.Resolve(user, permission)
+	if err != nil || unlimited(limit.RequestsPerMinute) {
+		return Result{Allowed: true}, err
+	}
+	return l.store.Take(counterKey("requests", user, permission), 1, *limit.RequestsPerMinute, requestWindow)
+}
+
+// TakeQuantity counts quantity against user's daily quota for permission
+func (l *Limiter) TakeQuantity(user, permission string, quantity int64) (Result, error) {
+	limit, err := l.Resolve(user, permission)
+	if err != nil || unlimited(limit.QuantityPerDay) {
+		return Result{Allowed: true}, err
+	}
+	return l.store.Take(counterKey("quantity", user, permission), quantity, *limit.QuantityPerDay, quantityWindow)
+}
+
+// ReturnQuantity gives back quantity taken for an operation that did not happen
+func (l *Limiter) ReturnQuantity(user, permission string, quantity int64) error {
+	return l.store.Return(counterKey("quantity", user, permission), quantity, quantityWindow)
+}
+
+// RetryAfter is the number of whole seconds until the result's window resets
+func (r Result) RetryAfter() int64 {
+	seconds := int64(time.Until(r.ResetAt).Seconds()) + 1
+	return max(seconds, 1)
+}
+
+// mostPermissive picks the higher of two limits. Nil has no opinion and
+// zero means unlimited.
+func mostPermissive(a, b *int64) *int64 {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case *a == 0:
+		return a
+	case *b == 0:
+		return b
+	case *b > *a:
+		return b
+	}
+	return a
+}
+
+// unlimited reports whether a resolved dimension puts no cap on usage
+func unlimited(limit *int64) bool {
+	return limit == nil || *limit == 0
+}
+
+func counterKey(kind, user, permission string) string {
+	return kind + ":" + user + ":" + permission
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import "testing"
+
+// roles mirrors the role hierarchy of config/policy.csv
+var roles = map[string][]string{
+	"alice": {"staff"},
+	"lee":   {"leader", "staff"},
+	"mia":   {"manager", "leader", "staff"},
+	"root":  {"root"},
+	"rita":  {"root", "staff"},
+}
+
+func newTestLimiter(t *testing.T) *Limiter {
+	t.Helper()
+
+	config, err := LoadConfig("../config/rate_limits.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLimiter(config, NewMemoryStore(), func(user string) ([]string, error) {
+		return roles[user], nil
+	})
+}
+
+func TestResolve(t *testing.T) {
+	l := newTestLimiter(t)
+
+	const unset = -1
+	tests := []struct {
+		user, permission   string
+		requests, quantity int64
+	}{
+		{"alice", "products:read", 120, unset},
+		{"alice", "stock:out", 20, 500},
+		{"lee", "stock:out", 60, 5000},
+		// manager's * request limit has no opinion on the quota it inherits
+		{"mia", "stock:out", 600, 5000},
+		{"mia", "products:read", 600, unset},
+		{"root", "stock:out", 0, 0},
+		{"rita", "stock:out", 0, 0},
+		{"nobody", "stock:out", unset, unset},
+	}
+	for _, tt := range tests {
+		limit, err := l.Resolve(tt.user, tt.permission)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := value(limit.RequestsPerMinute, unset); got != tt.requests {
+			t.Errorf("%s %s: requests_per_minute = %d, want %d", tt.user, tt.permission, got, tt.requests)
+		}
+		if got := value(limit.QuantityPerDay, unset); got != tt.quantity {
+			t.Errorf("%s %s: quantity_per_day = %d, want %d", tt.user, tt.permission, got, tt.quantity)
+		}
+	}
+}
+
+func TestManagerQuotaIsEnforced(t *testing.T) {
+	l := newTestLimiter(t)
+
+	if result, err := l.TakeQuantity("mia", "stock:out", 5000); err != nil || !result.Allowed {
+		t.Fatalf("taking the whole quota: %+v, %v", result, err)
+	}
+	if result, err := l.TakeQuantity("mia", "stock:out", 1); err != nil || result.Allowed {
+		t.Fatalf("taking beyond the quota: %+v, %v; want refused", result, err)
+	}
+}
+
+func value(limit *int64, unset int64) int64 {
+	if limit == nil {
+		return unset
+	}
+	return *limit
+}
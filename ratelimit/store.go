@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Result is the outcome of taking from a counter
+type Result struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// ResetAt is when the current window ends and the counter starts over
+	ResetAt time.Time
+}
+
+// Store keeps counters in fixed time windows. Implementations must be safe
+// for concurrent use; an external store can replace MemoryStore when several
+// instances share limits.
+type Store interface {
+	// Take adds amount to the counter for key in the current window if the
+	// total stays within limit
+	Take(key string, amount, limit int64, window time.Duration) (Result, error)
+	// Return gives back an amount taken earlier in the current window
+	Return(key string, amount int64, window time.Duration) error
+}
+
+type counter struct {
+	used    int64
+	resetAt time.Time
+}
+
+// MemoryStore is an in-process Store
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+	now      func() time.Time
+	sweepAt  time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]*counter),
+		now:      time.Now,
+	}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(key string, amount, limit int64, window time.Duration) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.current(key, window)
+	result := Result{Limit: limit, ResetAt: c.resetAt}
+	if c.used+amount > limit {
+		result.Remaining = max(limit-c.used, 0)
+		return result, nil
+	}
+
+	c.used += amount
+	result.Allowed = true
+	result.Remaining = limit - c.used
+	return result, nil
+}
+
+// Return implements Store
+func (s *MemoryStore) Return(key string, amount int64, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.current(key, window)
+	c.used = max(c.used-amount, 0)
+	return nil
+}
+
+// current returns the counter for key, starting a new window when the last one ended
+func (s *MemoryStore) current(key string, window time.Duration) *counter {
+	now := s.now()
+	s.sweep(now)
+
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.resetAt) {
+		c = &counter{resetAt: windowEnd(now, window)}
+		s.counters[key] = c
+	}
+	return c
+}
+
+// sweep drops expired counters at most once a minute
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Before(s.sweepAt) {
+		return
+	}
+	s.sweepAt = now.Add(time.Minute)
+
+	for key, c := range s.counters {
+		if !now.Before(c.resetAt) {
+			delete(s.counters, key)
+		}
+	}
+}
+
+// windowEnd returns the end of the window containing now. Daily windows end
+// at local midnight; shorter windows are aligned to multiples of their length.
+func windowEnd(now time.Time, window time.Duration) time.Time {
+	if window == 24*time.Hour {
+		year, month, day := now.Date()
+		return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+	}
+	return now.Truncate(window).Add(window)
+}
@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"strings"
+
+	"github.com/casbin/casbin/v2/util"
+)
+
+// MigrateRule converts a path-based p rule (sub, obj, act, eft, ...) into one
+// rule per registered route it grants, with the route's permission as object.
+// A * in the path matches whole route paths, not just a prefix as keyMatch
+// does, so /products/*/stocks/* no longer reaches PATCH /products/{productId}.
+// Rules whose object is not a URL path are returned unchanged. The second
+// result is false when a path rule matches no registered route.
+func MigrateRule(rule []string, routes []Route) ([][]string, bool) {
+	if len(rule) < 3 || !strings.HasPrefix(rule[1], "/") {
+		return [][]string{rule}, true
+	}
+
+	var migrated [][]string
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		if rule[2] != "*" && rule[2] != route.Method {
+			continue
+		}
+
+		path := SamplePath(route.Path)
+		if rule[1] != path && !util.KeyMatch2(path, rule[1]) {
+			continue
+		}
+
+		converted := append([]string{}, rule...)
+		converted[1] = route.Permission
+		converted[2] = route.Method
+
+		key := strings.Join(converted, ",")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		migrated = append(migrated, converted)
+	}
+
+	return migrated, len(migrated) > 0
+}
@@ -0,0 +1,175 @@
+package routes
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Route is a registered endpoint and the named permission that guards it
+type Route struct {
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Permission string `json:"permission"`
+}
+
+// Registry maps mux routes to named permissions such as "products:update"
+type Registry struct {
+	mu          sync.RWMutex
+	routes      []Route
+	routers     []*mux.Router
+	permissions map[*mux.Route]string
+}
+
+var (
+	// GlobalRegistry is the registry the server's routes are declared in
+	GlobalRegistry = NewRegistry()
+)
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		permissions: make(map[*mux.Route]string),
+	}
+}
+
+// GetRegistry returns the global registry instance
+func GetRegistry() *Registry {
+	return GlobalRegistry
+}
+
+// Handle registers handler on router for path and method, guarded by permission
+func (reg *Registry) Handle(router *mux.Router, method, path, permission string, handler http.HandlerFunc) *mux.Route {
+	route := router.HandleFunc(path, handler).Methods(method)
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if !containsRouter(reg.routers, router) {
+		reg.routers = append(reg.routers, router)
+	}
+	reg.permissions[route] = permission
+	reg.routes = append(reg.routes, Route{Method: method, Path: path, Permission: permission})
+
+	return route
+}
+
+// Permission returns the permission of the route that matched the request
+func (reg *Registry) Permission(r *http.Request) (string, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", false
+	}
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	permission, ok := reg.permissions[route]
+	return permission, ok
+}
+
+// Routes returns the registered routes sorted by path and method
+func (reg *Registry) Routes() []Route {
+	reg.mu.RLock()
+	routes := append([]Route{}, reg.routes...)
+	reg.mu.RUnlock()
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// Walk lists every route served by the routers the registry guards, including
+// routes added to them without a permission, which have an empty Permission
+func (reg *Registry) Walk() ([]Route, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	var walked []Route
+	for _, router := range reg.routers {
+		err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			if route.GetHandler() == nil {
+				return nil
+			}
+
+			path, err := route.GetPathTemplate()
+			if err != nil {
+				return err
+			}
+			methods, err := route.GetMethods()
+			if err != nil {
+				methods = []string{"*"}
+			}
+
+			for _, method := range methods {
+				walked = append(walked, Route{Method: method, Path: path, Permission: reg.permissions[route]})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(walked, func(i, j int) bool {
+		if walked[i].Path != walked[j].Path {
+			return walked[i].Path < walked[j].Path
+		}
+		return walked[i].Method < walked[j].Method
+	})
+	return walked, nil
+}
+
+// Resolve finds the registered route that serves a method and concrete path
+func (reg *Registry) Resolve(method, path string) (Route, bool) {
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		return Route{}, false
+	}
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	for _, router := range reg.routers {
+		var match mux.RouteMatch
+		if !router.Match(req, &match) || match.Route == nil {
+			continue
+		}
+
+		template, err := match.Route.GetPathTemplate()
+		if err != nil {
+			return Route{}, false
+		}
+		return Route{Method: method, Path: template, Permission: reg.permissions[match.Route]}, true
+	}
+	return Route{}, false
+}
+
+func containsRouter(routers []*mux.Router, router *mux.Router) bool {
+	for _, r := range routers {
+		if r == router {
+			return true
+		}
+	}
+	return false
+}
+
+// SamplePath turns a route template into a concrete path by replacing each
+// {param} with its name, e.g. /products/{productId} becomes /products/productId
+func SamplePath(template string) string {
+	parts := strings.Split(template, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			name := part[1 : len(part)-1]
+			if colon := strings.Index(name, ":"); colon >= 0 {
+				name = name[:colon]
+			}
+			parts[i] = name
+		}
+	}
+	return strings.Join(parts, "/")
+}